@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+	"github.com/adheeeem/wallet/pkg/wallet"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: walletctl <command> [flags]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  verify    check the audit log hash chain")
+	fmt.Fprintln(os.Stderr, "  query     list payments matching a filter expression")
+	fmt.Fprintln(os.Stderr, "  report    spending breakdown by category, status and month")
+	fmt.Fprintln(os.Stderr, "  remap     rename legacy categories in dump files")
+	fmt.Fprintln(os.Stderr, "  statement monthly account statement")
+	fmt.Fprintln(os.Stderr, "  phones    report invalid and duplicate phone numbers")
+	fmt.Fprintln(os.Stderr, "  screen    check accounts against a blocklist file")
+	fmt.Fprintln(os.Stderr, "  reconcile match a bank statement against deposits and payments")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "verify":
+		err = verify(os.Args[2:])
+	case "query":
+		err = query(os.Args[2:])
+	case "report":
+		err = report(os.Args[2:])
+	case "remap":
+		err = remap(os.Args[2:])
+	case "statement":
+		err = statement(os.Args[2:])
+	case "phones":
+		err = phones(os.Args[2:])
+	case "screen":
+		err = screen(os.Args[2:])
+	case "reconcile":
+		err = reconcile(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	file := fs.String("file", "audit.dump", "audit log file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	records, err := wallet.ReadAuditFile(*file)
+	if err != nil {
+		return err
+	}
+	var index int
+	head, err := wallet.ReadAuditHeadFile(*file)
+	if err == nil {
+		index, err = wallet.VerifyAuditHead(records, head)
+	} else {
+		// without the head only edits and gaps are detected, not truncation
+		fmt.Fprintf(os.Stderr, "%s: %v, the tail is not checked\n", *file, err)
+		index, err = wallet.VerifyAuditLog(records)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: first broken link at line %d: %w", *file, index+1, err)
+	}
+	fmt.Printf("%s: %d records, chain intact\n", *file, len(records))
+	return nil
+}
+
+func load(dir string) (*wallet.Service, error) {
+	svc := &wallet.Service{}
+	svc.SetActor("walletctl")
+	err := svc.Import(dir)
+	if err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+func query(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory with dump files")
+	sortBy := fs.String("sort", "", "sort key: amount, category, status, account or time")
+	desc := fs.Bool("desc", false, "sort in descending order")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: walletctl query [-dir dir] [-sort key] [-desc] 'expression'")
+	}
+
+	filter, err := wallet.ParseFilter(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	svc, err := load(*dir)
+	if err != nil {
+		return err
+	}
+	payments, err := svc.FilterPaymentByFnSorted(filter, 1000, wallet.PaymentSortKey(*sortBy), *desc)
+	if err != nil {
+		return err
+	}
+	for _, payment := range payments {
+		fmt.Printf("%s;%d;%s;%s;%d\n", payment.ID, payment.Amount, payment.Category, payment.Status, payment.AccountID)
+	}
+	return nil
+}
+
+func report(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory with dump files")
+	account := fs.Int64("account", 0, "account id, 0 for all accounts")
+	top := fs.Int("top", 5, "number of top categories")
+	format := fs.String("format", "json", "output format: json or csv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	svc, err := load(*dir)
+	if err != nil {
+		return err
+	}
+	result, err := svc.SpendingReport(context.Background(), wallet.ReportQuery{AccountID: *account, Top: *top}, 0)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "json":
+		return result.WriteJSON(os.Stdout)
+	case "csv":
+		return result.WriteCSV(os.Stdout)
+	}
+	return fmt.Errorf("unknown format %q", *format)
+}
+
+func remap(args []string) error {
+	fs := flag.NewFlagSet("remap", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory with dump files, categories.dump must list the new codes")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: walletctl remap [-dir dir] old=new ...")
+	}
+
+	mapping := make(map[types.PaymentCategory]types.PaymentCategory)
+	for _, arg := range fs.Args() {
+		pair := strings.SplitN(arg, "=", 2)
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			return fmt.Errorf("invalid mapping %q, want old=new", arg)
+		}
+		mapping[types.PaymentCategory(pair[0])] = types.PaymentCategory(pair[1])
+	}
+
+	svc, err := load(*dir)
+	if err != nil {
+		return err
+	}
+	changed, err := svc.RemapCategories(mapping)
+	if err != nil {
+		return err
+	}
+	err = svc.Export(*dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d records remapped\n", changed)
+	return nil
+}
+
+func statement(args []string) error {
+	fs := flag.NewFlagSet("statement", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory with dump files")
+	account := fs.Int64("account", 0, "account id")
+	month := fs.String("month", time.Now().Format("2006-01"), "statement month as yyyy-mm, or all for the whole history")
+	format := fs.String("format", "text", "output format: text, json, csv, ofx or qif")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	svc, err := load(*dir)
+	if err != nil {
+		return err
+	}
+	var result *wallet.Statement
+	if *month == "all" {
+		result, err = svc.HistoryStatement(*account)
+	} else {
+		var period time.Time
+		period, err = time.Parse("2006-01", *month)
+		if err != nil {
+			return err
+		}
+		result, err = svc.MonthlyStatement(*account, period.Year(), period.Month())
+	}
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "text":
+		return result.WriteText(os.Stdout)
+	case "json":
+		return result.WriteJSON(os.Stdout)
+	case "csv":
+		return result.WriteCSV(os.Stdout)
+	case "ofx":
+		return result.WriteOFX(os.Stdout)
+	case "qif":
+		return result.WriteQIF(os.Stdout)
+	}
+	return fmt.Errorf("unknown format %q", *format)
+}
+
+func phones(args []string) error {
+	fs := flag.NewFlagSet("phones", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory with dump files")
+	country := fs.String("country", wallet.DefaultCountryCode, "country code for numbers without one")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	svc, err := load(*dir)
+	if err != nil {
+		return err
+	}
+	err = svc.SetDefaultCountryCode(*country)
+	if err != nil {
+		return err
+	}
+	result := svc.PhoneReport()
+	for _, duplicate := range result.Duplicates {
+		fmt.Printf("duplicate %s: accounts %v\n", duplicate.Phone, duplicate.AccountIDs)
+	}
+	for _, id := range result.Invalid {
+		fmt.Printf("invalid phone: account %d\n", id)
+	}
+	if len(result.Duplicates) > 0 || len(result.Invalid) > 0 {
+		return fmt.Errorf("%d duplicates, %d invalid phones", len(result.Duplicates), len(result.Invalid))
+	}
+	return nil
+}
+
+func screen(args []string) error {
+	fs := flag.NewFlagSet("screen", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory with dump files")
+	list := fs.String("list", "blocklist.csv", "blocklist file, csv or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	svc, err := load(*dir)
+	if err != nil {
+		return err
+	}
+	matches, err := svc.LoadBlocklist(*list)
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		fmt.Printf("blocked phone %s: account %d\n", match.Phone, match.AccountID)
+	}
+	if len(matches) > 0 {
+		return fmt.Errorf("%d accounts on the blocklist", len(matches))
+	}
+	return nil
+}
+
+func reconcile(args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory with dump files")
+	file := fs.String("statement", "statement.csv", "bank statement, csv or camt.053 xml")
+	tolerance := fs.Duration("tolerance", 48*time.Hour, "allowed difference between booking and wallet dates")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	entries, err := wallet.ReadBankStatementFile(*file)
+	if err != nil {
+		return err
+	}
+	svc, err := load(*dir)
+	if err != nil {
+		return err
+	}
+	result := svc.Reconcile(entries, *tolerance)
+	switch *format {
+	case "text":
+		err = result.WriteText(os.Stdout)
+	case "json":
+		err = result.WriteJSON(os.Stdout)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+	if len(result.Mismatched) > 0 || len(result.UnmatchedBank) > 0 || len(result.UnmatchedWallet) > 0 {
+		return fmt.Errorf("%d mismatched, %d unmatched bank entries, %d unmatched wallet records", len(result.Mismatched), len(result.UnmatchedBank), len(result.UnmatchedWallet))
+	}
+	return nil
+}
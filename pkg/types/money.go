@@ -0,0 +1,151 @@
+package types
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrMoneyOverflow = errors.New("money overflow")
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// Money counts minor units: 100 dirams make one somoni.
+const MinorUnits = 100
+
+const Currency = "TJS"
+
+const (
+	MaxMoney Money = math.MaxInt64
+	MinMoney Money = math.MinInt64
+)
+
+func (m Money) Add(other Money) (Money, error) {
+	sum := m + other
+	if (other > 0 && sum < m) || (other < 0 && sum > m) {
+		return 0, ErrMoneyOverflow
+	}
+	return sum, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	diff := m - other
+	if (other > 0 && diff > m) || (other < 0 && diff < m) {
+		return 0, ErrMoneyOverflow
+	}
+	return diff, nil
+}
+
+func (m Money) Mul(n int64) (Money, error) {
+	if m == 0 || n == 0 {
+		return 0, nil
+	}
+	product := m * Money(n)
+	if product/Money(n) != m || (m == -1 && n == math.MinInt64) || (n == -1 && m == MinMoney) {
+		return 0, ErrMoneyOverflow
+	}
+	return product, nil
+}
+
+// Locale sets the separators of formatted amounts. Group 0 means no
+// thousands grouping.
+type Locale struct {
+	Decimal rune
+	Group   rune
+}
+
+var (
+	LocalePlain   = Locale{Decimal: '.'}
+	LocaleEnglish = Locale{Decimal: '.', Group: ','}
+	LocaleRussian = Locale{Decimal: ',', Group: '\u00a0'}
+	LocaleTajik   = Locale{Decimal: ',', Group: '\u00a0'}
+)
+
+// Decimal formats the amount in major units without grouping or currency,
+// e.g. "-1234.50", as bank file formats expect.
+func (m Money) Decimal() string {
+	return m.format(LocalePlain)
+}
+
+// Format writes the amount with the locale's separators and the currency,
+// e.g. "1 234,50 TJS" with a non-breaking space in the Tajik locale.
+func (m Money) Format(locale Locale) string {
+	return m.format(locale) + " " + Currency
+}
+
+func (m Money) format(locale Locale) string {
+	if locale.Decimal == 0 {
+		locale.Decimal = '.'
+	}
+	// go through uint64 so MinMoney doesn't overflow when negated
+	abs := uint64(m)
+	sign := ""
+	if m < 0 {
+		abs, sign = -abs, "-"
+	}
+	whole := strconv.FormatUint(abs/MinorUnits, 10)
+	fraction := strconv.FormatUint(abs%MinorUnits+MinorUnits, 10)[1:]
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, digit := range whole {
+		if locale.Group != 0 && i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteRune(locale.Group)
+		}
+		b.WriteRune(digit)
+	}
+	b.WriteRune(locale.Decimal)
+	b.WriteString(fraction)
+	return b.String()
+}
+
+// ParseMoney reads an amount written in major units with the locale's
+// separators, e.g. "1,234.5" or "-1 234,50 TJS". Spaces always work as
+// group separators and the currency code is optional.
+func ParseMoney(value string, locale Locale) (Money, error) {
+	if locale.Decimal == 0 {
+		locale.Decimal = '.'
+	}
+	raw := strings.TrimSpace(value)
+	raw = strings.TrimSpace(strings.TrimSuffix(raw, Currency))
+	raw = strings.TrimSpace(strings.TrimPrefix(raw, Currency))
+
+	negative := false
+	if strings.HasPrefix(raw, "-") || strings.HasPrefix(raw, "+") {
+		negative = raw[0] == '-'
+		raw = raw[1:]
+	}
+
+	var whole, fraction strings.Builder
+	part := &whole
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			part.WriteRune(r)
+		case r == locale.Decimal && part == &whole:
+			part = &fraction
+		case (r == locale.Group || r == ' ' || r == '\u00a0') && part == &whole && whole.Len() > 0:
+		default:
+			return 0, ErrInvalidMoney
+		}
+	}
+	if whole.Len() == 0 || fraction.Len() > 2 || (part == &fraction && fraction.Len() == 0) {
+		return 0, ErrInvalidMoney
+	}
+
+	digits := whole.String() + (fraction.String() + "00")[:2]
+	amount, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		return 0, ErrMoneyOverflow
+	}
+	if negative {
+		if amount > uint64(math.MaxInt64)+1 {
+			return 0, ErrMoneyOverflow
+		}
+		return Money(-amount), nil
+	}
+	if amount > math.MaxInt64 {
+		return 0, ErrMoneyOverflow
+	}
+	return Money(amount), nil
+}
@@ -0,0 +1,76 @@
+package types
+
+import (
+	"testing"
+)
+
+func TestMoney_checked(t *testing.T) {
+	if sum, err := Money(100).Add(50); err != nil || sum != 150 {
+		t.Errorf("Add(): sum = %d, error = %v", sum, err)
+	}
+	if _, err := MaxMoney.Add(1); err != ErrMoneyOverflow {
+		t.Errorf("Add(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+	if _, err := MinMoney.Sub(1); err != ErrMoneyOverflow {
+		t.Errorf("Sub(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+	if diff, err := Money(-5).Sub(-10); err != nil || diff != 5 {
+		t.Errorf("Sub(): diff = %d, error = %v", diff, err)
+	}
+	if product, err := Money(-250).Mul(4); err != nil || product != -1_000 {
+		t.Errorf("Mul(): product = %d, error = %v", product, err)
+	}
+	if _, err := (MaxMoney / 2).Mul(3); err != ErrMoneyOverflow {
+		t.Errorf("Mul(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+	if _, err := MinMoney.Mul(-1); err != ErrMoneyOverflow {
+		t.Errorf("Mul(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+}
+
+func TestMoney_Format(t *testing.T) {
+	tests := []struct {
+		money  Money
+		locale Locale
+		want   string
+	}{
+		{12345, LocalePlain, "123.45 TJS"},
+		{-5, LocalePlain, "-0.05 TJS"},
+		{123456789, LocaleEnglish, "1,234,567.89 TJS"},
+		{123456789, LocaleTajik, "1\u00a0234\u00a0567,89 TJS"},
+		{MinMoney, LocalePlain, "-92233720368547758.08 TJS"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.Format(tt.locale); got != tt.want {
+			t.Errorf("Format(%d) = %q, want %q", tt.money, got, tt.want)
+		}
+	}
+	if got := Money(-100050).Decimal(); got != "-1000.50" {
+		t.Errorf("Decimal() = %q, want %q", got, "-1000.50")
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value   string
+		locale  Locale
+		want    Money
+		wantErr error
+	}{
+		{"123.45 TJS", LocalePlain, 12345, nil},
+		{"1,234.5", LocaleEnglish, 123450, nil},
+		{"-1 234,56 TJS", LocaleTajik, -123456, nil},
+		{"7", LocaleRussian, 700, nil},
+		{"1.234", LocalePlain, 0, ErrInvalidMoney},
+		{"12.", LocalePlain, 0, ErrInvalidMoney},
+		{"abc", LocalePlain, 0, ErrInvalidMoney},
+		{"-92233720368547758.08", LocalePlain, MinMoney, nil},
+		{"92233720368547758.08", LocalePlain, 0, ErrMoneyOverflow},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.value, tt.locale)
+		if got != tt.want || err != tt.wantErr {
+			t.Errorf("ParseMoney(%q) = %d, %v, want %d, %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 type Money int64
 
 type PaymentCategory string
@@ -10,14 +12,18 @@ const (
 	PaymentStatusOk         PaymentStatus = "OK"
 	PaymentStatusFail       PaymentStatus = "FAIL"
 	PaymentStatusInProgress PaymentStatus = "INPROGRESS"
+	PaymentStatusReview     PaymentStatus = "REVIEW"
 )
 
 type Payment struct {
-	ID        string
-	Amount    Money
-	Category  PaymentCategory
-	Status    PaymentStatus
-	AccountID int64
+	ID          string
+	Amount      Money
+	Category    PaymentCategory
+	Status      PaymentStatus
+	AccountID   int64
+	Created     time.Time
+	Rejected    time.Time
+	RecipientID int64
 }
 type Phone string
 
@@ -25,6 +31,17 @@ type Account struct {
 	ID      int64
 	Phone   Phone
 	Balance Money
+
+	CreditLimit     Money
+	OverdraftRate   int64
+	OverdrawnSince  time.Time
+	InterestAccrued time.Time
+	Cashback        Money
+	Created         time.Time
+	// UnchargedInterest is overdraft interest accrued on earlier balances
+	// that AccrueOverdraftInterest hasn't charged yet, in millionths of a
+	// diram so the fractions of frequent accruals aren't lost.
+	UnchargedInterest int64
 }
 
 type Favorite struct {
@@ -34,3 +51,183 @@ type Favorite struct {
 	Amount    Money
 	Category  PaymentCategory
 }
+
+type AuditRecord struct {
+	Seq       int64
+	Time      time.Time
+	Actor     string
+	Operation string
+	Args      string
+	Result    string
+	PrevHash  string
+	Hash      string
+}
+
+// AuditHead anchors the end of an audit chain, so records cut from the
+// tail are detected too.
+type AuditHead struct {
+	Count int64
+	Hash  string
+}
+
+type Category struct {
+	Code   PaymentCategory
+	Name   string
+	Parent PaymentCategory
+	Active bool
+}
+
+type Deposit struct {
+	ID        string
+	AccountID int64
+	Amount    Money
+	Source    string
+	Created   time.Time
+	Reversed  time.Time
+}
+
+type PhoneChange struct {
+	AccountID int64
+	Old       Phone
+	New       Phone
+	Changed   time.Time
+}
+
+type Fee struct {
+	ID               string
+	PaymentID        string
+	AccountID        int64
+	RevenueAccountID int64
+	Amount           Money
+	Refunded         Money
+	Created          time.Time
+	RefundedAt       time.Time
+}
+
+type Reward struct {
+	ID         string
+	PaymentID  string
+	AccountID  int64
+	Rule       string
+	Amount     Money
+	Created    time.Time
+	ClawedBack time.Time
+}
+
+type VoucherKind string
+
+const (
+	VoucherCredit   VoucherKind = "CREDIT"
+	VoucherDiscount VoucherKind = "DISCOUNT"
+)
+
+type Voucher struct {
+	Code              string
+	Kind              VoucherKind
+	Amount            Money
+	Percent           int64
+	Expires           time.Time
+	MaxUses           int
+	MaxUsesPerAccount int
+	Uses              int
+	Created           time.Time
+}
+
+type VoucherRedemption struct {
+	ID        string
+	Code      string
+	AccountID int64
+	Amount    Money
+	DepositID string
+	PaymentID string
+	Created   time.Time
+	Reverted  time.Time
+}
+
+type RiskOutcome string
+
+const (
+	RiskAllow  RiskOutcome = "ALLOW"
+	RiskReview RiskOutcome = "REVIEW"
+	RiskDeny   RiskOutcome = "DENY"
+)
+
+type RiskDecision struct {
+	ID         string
+	PaymentID  string
+	AccountID  int64
+	Amount     Money
+	Category   PaymentCategory
+	RepeatOf   string
+	Outcome    RiskOutcome
+	Rule       string
+	Reason     string
+	Created    time.Time
+	Resolution RiskOutcome
+	Reviewer   string
+	Reviewed   time.Time
+}
+
+type ScreeningMatch struct {
+	ID        string
+	AccountID int64
+	Phone     Phone
+	Category  PaymentCategory
+	Operation string
+	Created   time.Time
+}
+
+type Payout struct {
+	PaymentID  string
+	MessageID  string
+	BatchID    string
+	EndToEndID string
+	Status     string
+	Reason     string
+	Created    time.Time
+	Updated    time.Time
+}
+
+type PaymentBatch struct {
+	ID         string
+	Mode       string
+	PaymentIDs []string
+	Created    time.Time
+	Rejected   time.Time
+}
+
+type SplitStatus string
+
+const (
+	SplitStatusPending  SplitStatus = "PENDING"
+	SplitStatusPaid     SplitStatus = "PAID"
+	SplitStatusDeclined SplitStatus = "DECLINED"
+	SplitStatusRejected SplitStatus = "REJECTED"
+)
+
+type ShareStatus string
+
+const (
+	ShareStatusPending  ShareStatus = "PENDING"
+	ShareStatusAccepted ShareStatus = "ACCEPTED"
+	ShareStatusDeclined ShareStatus = "DECLINED"
+)
+
+type PaymentSplit struct {
+	ID       string
+	Amount   Money
+	Category PaymentCategory
+	Mode     string
+	Status   SplitStatus
+	Created  time.Time
+	Updated  time.Time
+}
+
+type SplitShare struct {
+	SplitID   string
+	AccountID int64
+	Amount    Money
+	PaymentID string
+	Status    ShareStatus
+	Updated   time.Time
+}
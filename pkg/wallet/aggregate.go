@@ -0,0 +1,140 @@
+package wallet
+
+import (
+	"context"
+	"sort"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+type Ordered interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+		~float32 | ~float64 | ~string
+}
+
+// Aggregation describes a single parallel pass over payments: payments passing
+// Filter are mapped to a value, grouped by Key and folded with Reduce. Merge
+// combines the partial results of two chunks and must be associative. An
+// error from Reduce or Merge, such as types.ErrMoneyOverflow, stops the pass.
+type Aggregation[K Ordered, V any, R any] struct {
+	Filter func(payment types.Payment) bool
+	Map    func(payment types.Payment) V
+	Key    func(payment types.Payment) K
+	Reduce func(acc R, value V) (R, error)
+	Merge  func(a R, b R) (R, error)
+}
+
+type Group[K Ordered, R any] struct {
+	Key   K
+	Value R
+}
+
+type Average struct {
+	Sum   types.Money
+	Count int
+}
+
+func (a Average) Value() types.Money {
+	if a.Count == 0 {
+		return 0
+	}
+	return a.Sum / types.Money(a.Count)
+}
+
+// Aggregate runs agg over all payments of s and returns the groups sorted by key.
+func Aggregate[K Ordered, V any, R any](ctx context.Context, s *Service, agg Aggregation[K, V, R], workers int) ([]Group[K, R], error) {
+	return aggregatePayments(ctx, s.payments, agg, workers)
+}
+
+func aggregatePayments[K Ordered, V any, R any](ctx context.Context, payments []*types.Payment, agg Aggregation[K, V, R], workers int) ([]Group[K, R], error) {
+	workers = normalizeWorkers(workers)
+	parts := chunks(len(payments), chunkSize(len(payments), workers))
+	partials := make([]map[K]R, len(parts))
+	err := scanPayments(ctx, payments, workers, parts, func(index int, part []*types.Payment) error {
+		groups := make(map[K]R)
+		partials[index] = groups
+		return scanChunk(ctx, part, func(payment *types.Payment) error {
+			if agg.Filter != nil && !agg.Filter(*payment) {
+				return nil
+			}
+			key := agg.Key(*payment)
+			value, err := agg.Reduce(groups[key], agg.Map(*payment))
+			if err != nil {
+				return err
+			}
+			groups[key] = value
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[K]R)
+	for _, groups := range partials {
+		for key, value := range groups {
+			if acc, ok := merged[key]; ok {
+				value, err = agg.Merge(acc, value)
+				if err != nil {
+					return nil, err
+				}
+			}
+			merged[key] = value
+		}
+	}
+
+	result := make([]Group[K, R], 0, len(merged))
+	for key, value := range merged {
+		result = append(result, Group[K, R]{Key: key, Value: value})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result, nil
+}
+
+func sumMoney(a types.Money, b types.Money) (types.Money, error) {
+	return a.Add(b)
+}
+
+func sumInt(a int, b int) (int, error) {
+	return a + b, nil
+}
+
+func mergeAverage(a Average, b Average) (Average, error) {
+	sum, err := a.Sum.Add(b.Sum)
+	if err != nil {
+		return Average{}, err
+	}
+	return Average{Sum: sum, Count: a.Count + b.Count}, nil
+}
+
+func (s *Service) TotalByCategory(ctx context.Context, workers int) ([]Group[types.PaymentCategory, types.Money], error) {
+	return Aggregate(ctx, s, Aggregation[types.PaymentCategory, types.Money, types.Money]{
+		Map:    func(payment types.Payment) types.Money { return payment.Amount },
+		Key:    func(payment types.Payment) types.PaymentCategory { return payment.Category },
+		Reduce: sumMoney,
+		Merge:  sumMoney,
+	}, workers)
+}
+
+func (s *Service) CountByStatus(ctx context.Context, workers int) ([]Group[types.PaymentStatus, int], error) {
+	return Aggregate(ctx, s, Aggregation[types.PaymentStatus, int, int]{
+		Map:    func(payment types.Payment) int { return 1 },
+		Key:    func(payment types.Payment) types.PaymentStatus { return payment.Status },
+		Reduce: sumInt,
+		Merge:  sumInt,
+	}, workers)
+}
+
+func (s *Service) AverageByAccount(ctx context.Context, workers int) ([]Group[int64, Average], error) {
+	return Aggregate(ctx, s, Aggregation[int64, types.Money, Average]{
+		Map: func(payment types.Payment) types.Money { return payment.Amount },
+		Key: func(payment types.Payment) int64 { return payment.AccountID },
+		Reduce: func(acc Average, amount types.Money) (Average, error) {
+			return mergeAverage(acc, Average{Sum: amount, Count: 1})
+		},
+		Merge: mergeAverage,
+	}, workers)
+}
@@ -0,0 +1,110 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestService_AverageByAccount(t *testing.T) {
+	s := newServiceWithPayments(t, 10_000)
+	got, err := s.AverageByAccount(context.Background(), 4)
+	if err != nil {
+		t.Errorf("AverageByAccount(): error = %v", err)
+		return
+	}
+	want := []Group[int64, Average]{
+		{Key: 1, Value: Average{Sum: 25_000, Count: 5_000}},
+		{Key: 2, Value: Average{Sum: 30_000, Count: 5_000}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AverageByAccount(): got %v, want %v", got, want)
+		return
+	}
+	if got[1].Value.Value() != 6 {
+		t.Errorf("AverageByAccount(): got average %v, want %v", got[1].Value.Value(), 6)
+	}
+}
+
+func TestService_TotalByCategory_ordered(t *testing.T) {
+	s := newTestService()
+	_, err := s.addAccountWithBalance("+992985570302", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, category := range []types.PaymentCategory{"taxi", "auto", "grocery", "auto"} {
+		_, err = s.Pay(1, 10, category)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	for i := 0; i < 10; i++ {
+		got, err := s.TotalByCategory(context.Background(), 3)
+		if err != nil {
+			t.Errorf("TotalByCategory(): error = %v", err)
+			return
+		}
+		want := []Group[types.PaymentCategory, types.Money]{
+			{Key: "auto", Value: 20},
+			{Key: "grocery", Value: 10},
+			{Key: "taxi", Value: 10},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("TotalByCategory(): got %v, want %v", got, want)
+			return
+		}
+	}
+}
+
+func TestService_TotalByCategory_overflow(t *testing.T) {
+	s := newTestService()
+	for _, phone := range []types.Phone{"+992985570302", "+992981111111"} {
+		account, err := s.addAccountWithBalance(phone, types.MaxMoney)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, err = s.Pay(account.ID, types.MaxMoney, "grocery")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	_, err := s.TotalByCategory(context.Background(), 2)
+	if !errors.Is(err, types.ErrMoneyOverflow) {
+		t.Errorf("TotalByCategory(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+}
+
+func TestAggregate_filterAndCount(t *testing.T) {
+	s := newServiceWithPayments(t, 1_000)
+	err := s.Reject(s.payments[0].ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	got, err := Aggregate(context.Background(), s.Service, Aggregation[types.PaymentStatus, int, int]{
+		Filter: func(payment types.Payment) bool { return payment.AccountID == 1 },
+		Map:    func(payment types.Payment) int { return 1 },
+		Key:    func(payment types.Payment) types.PaymentStatus { return payment.Status },
+		Reduce: sumInt,
+		Merge:  sumInt,
+	}, 2)
+	if err != nil {
+		t.Errorf("Aggregate(): error = %v", err)
+		return
+	}
+	want := []Group[types.PaymentStatus, int]{
+		{Key: types.PaymentStatusFail, Value: 1},
+		{Key: types.PaymentStatusInProgress, Value: 499},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Aggregate(): got %v, want %v", got, want)
+	}
+}
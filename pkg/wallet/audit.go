@@ -0,0 +1,283 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+var ErrAuditChainBroken = errors.New("audit chain broken")
+var ErrAuditLogStarted = errors.New("audit log already started")
+
+const auditResultOk = "ok"
+
+func (s *Service) SetActor(actor string) {
+	s.actor = actor
+}
+
+func (s *Service) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *Service) record(operation string, args string, result string, err error) {
+	if err != nil {
+		result = "error: " + err.Error()
+	} else if result == "" {
+		result = auditResultOk
+	}
+
+	actor := s.actor
+	if actor == "" {
+		actor = "system"
+	}
+
+	prevHash := ""
+	if len(s.auditLog) > 0 {
+		prevHash = s.auditLog[len(s.auditLog)-1].Hash
+	}
+	record := &types.AuditRecord{
+		Seq:       int64(len(s.auditLog)) + 1,
+		Time:      s.clock().UTC(),
+		Actor:     actor,
+		Operation: operation,
+		Args:      args,
+		Result:    result,
+		PrevHash:  prevHash,
+	}
+	record.Hash = auditHash(*record)
+	s.auditLog = append(s.auditLog, record)
+}
+
+func auditHash(record types.AuditRecord) string {
+	h := sha256.New()
+	fields := []string{
+		strconv.FormatInt(record.Seq, 10),
+		record.Time.UTC().Format(time.RFC3339Nano),
+		record.Actor,
+		record.Operation,
+		record.Args,
+		record.Result,
+		record.PrevHash,
+	}
+	for _, field := range fields {
+		h.Write([]byte(strconv.Itoa(len(field))))
+		h.Write([]byte{':'})
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func auditArgs(pairs ...interface{}) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%v=%v", pairs[i], pairs[i+1]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *Service) AuditLog() []types.AuditRecord {
+	records := make([]types.AuditRecord, len(s.auditLog))
+	for i, record := range s.auditLog {
+		records[i] = *record
+	}
+	return records
+}
+
+func (s *Service) VerifyAudit() (int, error) {
+	return VerifyAuditLog(s.AuditLog())
+}
+
+// VerifyAuditLog walks the chain and returns the index of the first record
+// that does not match its own hash or its predecessor, or -1 if the chain is intact.
+func VerifyAuditLog(records []types.AuditRecord) (int, error) {
+	prevHash := ""
+	for i, record := range records {
+		if record.Seq != int64(i)+1 {
+			return i, fmt.Errorf("%w: record %d has seq %d", ErrAuditChainBroken, i, record.Seq)
+		}
+		if record.PrevHash != prevHash {
+			return i, fmt.Errorf("%w: record %d does not link to record %d", ErrAuditChainBroken, record.Seq, record.Seq-1)
+		}
+		if auditHash(record) != record.Hash {
+			return i, fmt.Errorf("%w: record %d was modified", ErrAuditChainBroken, record.Seq)
+		}
+		prevHash = record.Hash
+	}
+	return -1, nil
+}
+
+// AuditHead returns the anchor of the current audit chain. Keep it apart
+// from the log to detect truncation with VerifyAuditHead.
+func (s *Service) AuditHead() types.AuditHead {
+	return auditHead(s.AuditLog())
+}
+
+func auditHead(records []types.AuditRecord) types.AuditHead {
+	head := types.AuditHead{Count: int64(len(records))}
+	if len(records) > 0 {
+		head.Hash = records[len(records)-1].Hash
+	}
+	return head
+}
+
+// VerifyAuditHead verifies the chain like VerifyAuditLog and then checks
+// that it ends at the head: as many records and the same last hash.
+func VerifyAuditHead(records []types.AuditRecord, head types.AuditHead) (int, error) {
+	index, err := VerifyAuditLog(records)
+	if err != nil {
+		return index, err
+	}
+	if int64(len(records)) < head.Count {
+		return len(records), fmt.Errorf("%w: %d records missing from the tail", ErrAuditChainBroken, head.Count-int64(len(records)))
+	}
+	if int64(len(records)) > head.Count || auditHead(records).Hash != head.Hash {
+		return int(head.Count), fmt.Errorf("%w: chain doesn't end at the head record %d", ErrAuditChainBroken, head.Count)
+	}
+	return -1, nil
+}
+
+// auditHeadPath is where ExportAudit puts the head of the exported log.
+func auditHeadPath(path string) string {
+	return path + ".head"
+}
+
+// ExportAudit writes the log to path and its head to path + ".head".
+func (s *Service) ExportAudit(path string) error {
+	records := s.AuditLog()
+	head := auditHead(records)
+	err := writeRecords(auditHeadPath(path), [][]string{{strconv.FormatInt(head.Count, 10), head.Hash}})
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	return writeAudit(file, records)
+}
+
+func writeAudit(w io.Writer, records []types.AuditRecord) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = ';'
+	for _, record := range records {
+		err := writer.Write([]string{
+			strconv.FormatInt(record.Seq, 10),
+			record.Time.UTC().Format(time.RFC3339Nano),
+			record.Actor,
+			record.Operation,
+			record.Args,
+			record.Result,
+			record.PrevHash,
+			record.Hash,
+		})
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func ReadAuditFile(path string) ([]types.AuditRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	return readAudit(file)
+}
+
+// ReadAuditHeadFile reads the head ExportAudit wrote next to the log file.
+func ReadAuditHeadFile(path string) (types.AuditHead, error) {
+	rows, err := readRecords(auditHeadPath(path))
+	if err != nil {
+		return types.AuditHead{}, err
+	}
+	if len(rows) != 1 || len(rows[0]) != 2 {
+		return types.AuditHead{}, fmt.Errorf("%w: no head for %s", ErrAuditChainBroken, path)
+	}
+	count, err := strconv.ParseInt(rows[0][0], 10, 64)
+	if err != nil {
+		return types.AuditHead{}, fmt.Errorf("%w: invalid head for %s", ErrAuditChainBroken, path)
+	}
+	return types.AuditHead{Count: count, Hash: rows[0][1]}, nil
+}
+
+func readAudit(r io.Reader) ([]types.AuditRecord, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = ';'
+	reader.FieldsPerRecord = 8
+
+	var records []types.AuditRecord
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Print(err)
+			return nil, err
+		}
+		seq, err := strconv.ParseInt(line[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		at, err := time.Parse(time.RFC3339Nano, line[1])
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, types.AuditRecord{
+			Seq:       seq,
+			Time:      at,
+			Actor:     line[2],
+			Operation: line[3],
+			Args:      line[4],
+			Result:    line[5],
+			PrevHash:  line[6],
+			Hash:      line[7],
+		})
+	}
+	return records, nil
+}
+
+func accountResult(account *types.Account) string {
+	if account == nil {
+		return ""
+	}
+	return "account=" + strconv.FormatInt(account.ID, 10)
+}
+
+func paymentResult(payment *types.Payment) string {
+	if payment == nil {
+		return ""
+	}
+	return "payment=" + payment.ID
+}
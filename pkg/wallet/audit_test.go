@@ -0,0 +1,239 @@
+package wallet
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestService_AuditLog_recordsMutations(t *testing.T) {
+	s := newTestService()
+	s.SetActor("operator")
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Errorf("Reject(): error = %v", err)
+		return
+	}
+
+	records := s.AuditLog()
+	wantOps := []string{"RegisterAccount", "Deposit", "Pay", "Reject"}
+	if len(records) != len(wantOps) {
+		t.Errorf("AuditLog(): got %d records, want %d", len(records), len(wantOps))
+		return
+	}
+	for i, op := range wantOps {
+		if records[i].Operation != op || records[i].Actor != "operator" {
+			t.Errorf("AuditLog(): record %d = %v, want operation %s", i, records[i], op)
+		}
+	}
+	if records[2].Result != "payment="+payments[0].ID {
+		t.Errorf("AuditLog(): wrong Pay result = %v", records[2].Result)
+	}
+
+	index, err := s.VerifyAudit()
+	if err != nil || index != -1 {
+		t.Errorf("VerifyAudit(): index = %d, error = %v", index, err)
+	}
+}
+
+func TestService_AuditLog_recordsSettings(t *testing.T) {
+	s := newTestService()
+	s.SetCategoryMode(CategoryModeStrict)
+	s.SetRiskChecks(func(RiskRequest) RiskVerdict { return RiskVerdict{} })
+	err := s.SetDefaultCountryCode("+999")
+	if !errors.Is(err, ErrInvalidCountryCode) {
+		t.Errorf("SetDefaultCountryCode(): must return ErrInvalidCountryCode, returned = %v", err)
+	}
+
+	records := s.AuditLog()
+	want := []types.AuditRecord{
+		{Operation: "SetCategoryMode", Args: "mode=1", Result: auditResultOk},
+		{Operation: "SetRiskChecks", Args: "checks=1", Result: auditResultOk},
+		{Operation: "SetDefaultCountryCode", Args: "code=999", Result: "error: " + ErrInvalidCountryCode.Error()},
+	}
+	if len(records) != len(want) {
+		t.Errorf("AuditLog(): got %v, want %v", records, want)
+		return
+	}
+	for i := range want {
+		if records[i].Operation != want[i].Operation || records[i].Args != want[i].Args || records[i].Result != want[i].Result {
+			t.Errorf("AuditLog(): record %d = %v, want %v", i, records[i], want[i])
+		}
+	}
+}
+
+func TestVerifyAuditLog_detectsTampering(t *testing.T) {
+	s := newTestService()
+	_, _, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(1, 1, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	edited := s.AuditLog()
+	edited[1].Args = "account=1 amount=1"
+	index, err := VerifyAuditLog(edited)
+	if !errors.Is(err, ErrAuditChainBroken) || index != 1 {
+		t.Errorf("VerifyAuditLog(): edited record, index = %d, error = %v", index, err)
+	}
+
+	deleted := s.AuditLog()
+	deleted = append(deleted[:2], deleted[3:]...)
+	index, err = VerifyAuditLog(deleted)
+	if !errors.Is(err, ErrAuditChainBroken) || index != 2 {
+		t.Errorf("VerifyAuditLog(): deleted record, index = %d, error = %v", index, err)
+	}
+}
+
+func TestService_ExportAudit_roundTrip(t *testing.T) {
+	s := newTestService()
+	_, _, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.FavoritePayment("missing", "name; with \"quotes\"")
+	if err == nil {
+		t.Error("FavoritePayment(): must return error, returned nil")
+		return
+	}
+
+	path := t.TempDir() + "/audit.dump"
+	err = s.ExportAudit(path)
+	if err != nil {
+		t.Errorf("ExportAudit(): error = %v", err)
+		return
+	}
+	records, err := ReadAuditFile(path)
+	if err != nil {
+		t.Errorf("ReadAuditFile(): error = %v", err)
+		return
+	}
+	if len(records) != len(s.AuditLog()) {
+		t.Errorf("ReadAuditFile(): got %d records, want %d", len(records), len(s.AuditLog()))
+		return
+	}
+	index, err := VerifyAuditLog(records)
+	if err != nil {
+		t.Errorf("VerifyAuditLog(): index = %d, error = %v", index, err)
+	}
+}
+
+func TestVerifyAuditHead_detectsTruncation(t *testing.T) {
+	s := newTestService()
+	_, _, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	head := s.AuditHead()
+	records := s.AuditLog()
+	if index, err := VerifyAuditHead(records, head); err != nil {
+		t.Errorf("VerifyAuditHead(): index = %d, error = %v", index, err)
+	}
+	index, err := VerifyAuditHead(records[:len(records)-1], head)
+	if !errors.Is(err, ErrAuditChainBroken) || index != len(records)-1 {
+		t.Errorf("VerifyAuditHead(): truncated log, index = %d, error = %v", index, err)
+	}
+}
+
+func TestService_Import_verifiesAudit(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.FavoritePayment(payments[0].ID, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Errorf("Export(): error = %v", err)
+		return
+	}
+	err = newTestService().Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+	// a service with its own log can't take the dump's
+	started := newTestService()
+	started.SetCategoryMode(CategoryModeStrict)
+	err = started.Import(dir)
+	if !errors.Is(err, ErrAuditLogStarted) {
+		t.Errorf("Import(): must return ErrAuditLogStarted, returned = %v", err)
+	}
+
+	records := s.AuditLog()
+	file, err := os.Create(dir + "/audit.dump")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = writeAudit(file, records[:len(records)-1])
+	file.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = newTestService().Import(dir)
+	if !errors.Is(err, ErrAuditChainBroken) {
+		t.Errorf("Import(): truncated audit log must be refused, error = %v", err)
+	}
+}
+
+func TestService_PayFromFavorite_auditsOuterCall(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	before := len(s.AuditLog())
+	payment, err := s.PayFromFavorite(favorite.ID)
+	if err != nil || payment == nil {
+		t.Errorf("PayFromFavorite(): payment = %v, error = %v", payment, err)
+		return
+	}
+	_, err = s.Repeat(payment.ID)
+	if err != nil {
+		t.Errorf("Repeat(): error = %v", err)
+		return
+	}
+
+	records := s.AuditLog()[before:]
+	wantOps := []string{"PayFromFavorite", "Repeat"}
+	if len(records) != len(wantOps) {
+		t.Errorf("AuditLog(): got %d records, want only the outer calls", len(records))
+		return
+	}
+	for i, op := range wantOps {
+		if records[i].Operation != op {
+			t.Errorf("AuditLog(): record %d = %s, want %s", i, records[i].Operation, op)
+		}
+	}
+	if records[0].Result != "payment="+payment.ID {
+		t.Errorf("AuditLog(): PayFromFavorite result = %s", records[0].Result)
+	}
+}
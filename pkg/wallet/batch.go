@@ -0,0 +1,372 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/adheeeem/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidBatch = errors.New("invalid payment batch")
+var ErrBatchFailed = errors.New("payment batch failed")
+var ErrBatchNotFound = errors.New("payment batch not found")
+var ErrBatchRejected = errors.New("payment batch already rejected")
+
+// ErrBatchRollbackFailed is returned when a failed all-or-nothing batch
+// couldn't reject every payment it made. The results of those payments carry
+// the reason and the batch is kept, so RejectBatch can finish the rollback.
+var ErrBatchRollbackFailed = errors.New("payment batch rollback failed")
+
+// ErrBatchHeld is the result of an all-or-nothing item whose payment was
+// held for review: a later decline would leave the batch half paid.
+var ErrBatchHeld = errors.New("payment batch item held for review")
+
+// ErrBatchAborted is the result of items of an all-or-nothing batch that
+// weren't paid, or were rolled back, because another item failed.
+var ErrBatchAborted = errors.New("payment batch aborted")
+
+type BatchMode string
+
+const (
+	// BatchAllOrNothing pays every item or none of them.
+	BatchAllOrNothing BatchMode = "all_or_nothing"
+	// BatchBestEffort pays whatever items it can.
+	BatchBestEffort BatchMode = "best_effort"
+)
+
+type BatchItem struct {
+	AccountID int64
+	Amount    types.Money
+	Category  types.PaymentCategory
+}
+
+// BatchResult is the outcome of one item: the payment made for it or the
+// reason it wasn't paid. Rolled back items keep their rejected payment.
+type BatchResult struct {
+	Item    BatchItem
+	Payment *types.Payment
+	Err     error
+}
+
+// BatchReport has one result per item, in the order the items were given.
+type BatchReport struct {
+	ID      string
+	Mode    BatchMode
+	Results []BatchResult
+	Paid    int
+	Failed  int
+}
+
+// PayBatch pays the items like Pay. The audit record names the batch, whose
+// payments FindBatch lists.
+// Items of different accounts run on at most workers goroutines while the
+// items of one account are paid one by one in the given order.
+// The batch is kept under the report ID for FindBatch and RejectBatch unless
+// an all-or-nothing batch fails: then every payment made is rejected again
+// and ErrBatchFailed is returned with the report showing which items failed.
+// A payment held for review fails an all-or-nothing batch; a best-effort
+// batch counts it as paid.
+func (s *Service) PayBatch(ctx context.Context, items []BatchItem, mode BatchMode, workers int) (report *BatchReport, err error) {
+	defer func() {
+		result := ""
+		if report != nil && err == nil {
+			result = "batch=" + report.ID
+		}
+		s.record("PayBatch", auditArgs("items", len(items), "mode", mode), result, err)
+	}()
+
+	if len(items) == 0 || (mode != BatchAllOrNothing && mode != BatchBestEffort) {
+		return nil, ErrInvalidBatch
+	}
+	report = &BatchReport{ID: uuid.New().String(), Mode: mode, Results: make([]BatchResult, len(items))}
+	for i, item := range items {
+		report.Results[i].Item = item
+	}
+
+	if mode == BatchAllOrNothing && !s.batchFeasible(report.Results) {
+		abortBatch(report)
+		return report, ErrBatchFailed
+	}
+	s.runBatch(ctx, report.Results, mode, workers)
+
+	for _, result := range report.Results {
+		if result.Err != nil {
+			report.Failed++
+		}
+	}
+	if mode == BatchAllOrNothing && report.Failed > 0 {
+		err = s.rollbackBatch(report.Results)
+		abortBatch(report)
+		if err != nil {
+			s.keepBatch(report)
+			return report, err
+		}
+		return report, ErrBatchFailed
+	}
+
+	report.Paid = len(s.keepBatch(report).PaymentIDs)
+	return report, ctx.Err()
+}
+
+// keepBatch stores the batch of every payment in the report.
+func (s *Service) keepBatch(report *BatchReport) *types.PaymentBatch {
+	batch := &types.PaymentBatch{ID: report.ID, Mode: string(report.Mode), Created: s.clock().UTC()}
+	for _, result := range report.Results {
+		if result.Payment != nil {
+			batch.PaymentIDs = append(batch.PaymentIDs, result.Payment.ID)
+		}
+	}
+	s.batches = append(s.batches, batch)
+	return batch
+}
+
+// batchFeasible checks an all-or-nothing batch before any money moves:
+// accounts, amounts, categories, blocklists and whether each account can
+// cover all of its items with fees. Risk checks only run with the payments,
+// their denials are rolled back like any other failure.
+func (s *Service) batchFeasible(results []BatchResult) bool {
+	totals := make(map[int64]types.Money)
+	feasible := true
+	for i := range results {
+		results[i].Err = s.checkBatchItem(results[i].Item, totals)
+		if results[i].Err != nil {
+			feasible = false
+		}
+	}
+	return feasible
+}
+
+func (s *Service) checkBatchItem(item BatchItem, totals map[int64]types.Money) error {
+	if item.Amount <= 0 {
+		return ErrAmountMustBePositive
+	}
+	// permissive validation counts unknown categories, Pay will do that
+	if s.categoryMode != CategoryModePermissive {
+		err := s.validateCategory(item.Category)
+		if err != nil {
+			return err
+		}
+	}
+	account, err := s.FindAccountByID(item.AccountID)
+	if err != nil {
+		return err
+	}
+	if s.phoneBlocked(account.Phone) {
+		return ErrBlockedPhone
+	}
+	if s.blockedCategories[item.Category] {
+		return ErrBlockedCategory
+	}
+
+	total, err := totals[account.ID].Add(item.Amount)
+	if err != nil {
+		return err
+	}
+	fee, err := s.feeFor(account, item.Category, item.Amount)
+	if err != nil {
+		return err
+	}
+	total, err = total.Add(fee)
+	if err != nil {
+		return err
+	}
+	if available(account) < total {
+		return ErrNotEnoughBalance
+	}
+	totals[account.ID] = total
+	return nil
+}
+
+// batchGroups splits the item indexes by account, keeping the item order
+// within each account and the accounts in order of first appearance.
+func batchGroups(results []BatchResult) [][]int {
+	var groups [][]int
+	index := make(map[int64]int)
+	for i, result := range results {
+		group, ok := index[result.Item.AccountID]
+		if !ok {
+			group = len(groups)
+			index[result.Item.AccountID] = group
+			groups = append(groups, nil)
+		}
+		groups[group] = append(groups[group], i)
+	}
+	return groups
+}
+
+// runBatch gives each account's items to one of at most workers goroutines,
+// which pays them in the given order. Each payment changes shared state, the
+// payments, fees and risk decisions, so it is made under s.mu; an
+// all-or-nothing batch stops taking new items after a failure.
+func (s *Service) runBatch(ctx context.Context, results []BatchResult, mode BatchMode, workers int) {
+	groups := batchGroups(results)
+	workers = normalizeWorkers(workers)
+	if workers > len(groups) {
+		workers = len(groups)
+	}
+
+	stop, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan []int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				for _, index := range job {
+					result := &results[index]
+					if err := ctx.Err(); err != nil {
+						result.Err = err
+						continue
+					}
+					if stop.Err() != nil {
+						result.Err = ErrBatchAborted
+						continue
+					}
+					s.payBatchItem(result, mode)
+					if result.Err != nil && mode == BatchAllOrNothing {
+						cancel()
+					}
+				}
+			}
+		}()
+	}
+	for _, group := range groups {
+		jobs <- group
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// payBatchItem pays one item under s.mu. A payment held for review fails an
+// all-or-nothing batch.
+func (s *Service) payBatchItem(result *BatchResult, mode BatchMode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result.Payment, result.Err = s.checkedPay(result.Item.AccountID, result.Item.Amount, result.Item.Category, "")
+	if mode == BatchAllOrNothing && result.Payment != nil && result.Payment.Status == types.PaymentStatusReview {
+		result.Err = ErrBatchHeld
+	}
+}
+
+// rollbackBatch rejects the payments made by a failed all-or-nothing batch.
+// Items whose payment couldn't be rejected get ErrBatchRollbackFailed with
+// the reason, which is also returned.
+func (s *Service) rollbackBatch(results []BatchResult) error {
+	var failed error
+	for i := range results {
+		result := &results[i]
+		if result.Payment == nil {
+			continue
+		}
+		if err := s.reject(result.Payment.ID); err != nil {
+			result.Err = fmt.Errorf("%w: %v", ErrBatchRollbackFailed, err)
+			failed = ErrBatchRollbackFailed
+		}
+	}
+	return failed
+}
+
+// abortBatch marks every item that didn't fail on its own as aborted.
+func abortBatch(report *BatchReport) {
+	report.Paid, report.Failed = 0, len(report.Results)
+	for i := range report.Results {
+		if report.Results[i].Err == nil {
+			report.Results[i].Err = ErrBatchAborted
+		}
+	}
+}
+
+func (s *Service) FindBatch(batchID string) (*types.PaymentBatch, error) {
+	for _, batch := range s.batches {
+		if batch.ID == batchID {
+			return batch, nil
+		}
+	}
+	return nil, ErrBatchNotFound
+}
+
+// BatchPayments returns the current state of the batch's payments.
+func (s *Service) BatchPayments(batchID string) ([]types.Payment, error) {
+	batch, err := s.FindBatch(batchID)
+	if err != nil {
+		return nil, err
+	}
+	payments := make([]types.Payment, 0, len(batch.PaymentIDs))
+	for _, id := range batch.PaymentIDs {
+		payment, err := s.FindPaymentByID(id)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *payment)
+	}
+	return payments, nil
+}
+
+// RejectBatch rejects every payment of the batch that isn't rejected yet.
+func (s *Service) RejectBatch(batchID string) (err error) {
+	defer func() {
+		s.record("RejectBatch", auditArgs("batch", batchID), "", err)
+	}()
+
+	batch, err := s.FindBatch(batchID)
+	if err != nil {
+		return err
+	}
+	if !batch.Rejected.IsZero() {
+		return ErrBatchRejected
+	}
+	for _, id := range batch.PaymentIDs {
+		payment, err := s.FindPaymentByID(id)
+		if err != nil {
+			return err
+		}
+		if payment.Status == types.PaymentStatusFail {
+			continue
+		}
+		err = s.reject(id)
+		if err != nil {
+			return err
+		}
+	}
+	batch.Rejected = s.clock().UTC()
+	return nil
+}
+
+func batchRows(batches []*types.PaymentBatch) [][]string {
+	rows := make([][]string, len(batches))
+	for i, batch := range batches {
+		rows[i] = []string{
+			batch.ID,
+			batch.Mode,
+			strings.Join(batch.PaymentIDs, ","),
+			formatTime(batch.Created),
+			formatTime(batch.Rejected),
+		}
+	}
+	return rows
+}
+
+func parseBatches(rows [][]string) []*types.PaymentBatch {
+	batches := make([]*types.PaymentBatch, 0, len(rows))
+	for _, row := range rows {
+		batch := &types.PaymentBatch{
+			ID:       row[0],
+			Mode:     dumpField(row, 1),
+			Created:  parseTime(dumpField(row, 3)),
+			Rejected: parseTime(dumpField(row, 4)),
+		}
+		if ids := dumpField(row, 2); ids != "" {
+			batch.PaymentIDs = strings.Split(ids, ",")
+		}
+		batches = append(batches, batch)
+	}
+	return batches
+}
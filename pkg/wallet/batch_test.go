@@ -0,0 +1,242 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestService_PayBatch_bestEffort(t *testing.T) {
+	s := newTestService()
+	first, err := s.addAccountWithBalance("+992985570302", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.addAccountWithBalance("+992900000000", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	items := []BatchItem{
+		{AccountID: 1, Amount: 600, Category: "salary"},
+		{AccountID: 2, Amount: 2_000, Category: "salary"},
+		{AccountID: 1, Amount: 600, Category: "salary"},
+		{AccountID: 3, Amount: 100, Category: "salary"},
+		{AccountID: 2, Amount: 3_000, Category: "salary"},
+	}
+	report, err := s.PayBatch(context.Background(), items, BatchBestEffort, 4)
+	if err != nil {
+		t.Errorf("PayBatch(): error = %v", err)
+		return
+	}
+	if report.Paid != 3 || report.Failed != 2 {
+		t.Errorf("PayBatch(): paid = %d, failed = %d", report.Paid, report.Failed)
+	}
+	// items of one account are paid in order, so the second 600 fails
+	if report.Results[0].Payment == nil || report.Results[2].Err != ErrNotEnoughBalance || report.Results[3].Err != ErrAccountNotFound {
+		t.Errorf("PayBatch(): results = %v", report.Results)
+	}
+	if first.Balance != 400 || second.Balance != 5_000 {
+		t.Errorf("PayBatch(): balances = %d, %d", first.Balance, second.Balance)
+	}
+
+	payments, err := s.BatchPayments(report.ID)
+	if err != nil || len(payments) != 3 {
+		t.Errorf("BatchPayments(): payments = %v, error = %v", payments, err)
+		return
+	}
+	err = s.RejectBatch(report.ID)
+	if err != nil {
+		t.Errorf("RejectBatch(): error = %v", err)
+		return
+	}
+	if first.Balance != 1_000 || second.Balance != 10_000 {
+		t.Errorf("RejectBatch(): balances = %d, %d", first.Balance, second.Balance)
+	}
+	if err := s.RejectBatch(report.ID); err != ErrBatchRejected {
+		t.Errorf("RejectBatch(): must return ErrBatchRejected, returned = %v", err)
+	}
+}
+
+func TestService_PayBatch_allOrNothing(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992985570302", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	items := []BatchItem{
+		{AccountID: 1, Amount: 600, Category: "salary"},
+		{AccountID: 1, Amount: 600, Category: "salary"},
+	}
+	report, err := s.PayBatch(context.Background(), items, BatchAllOrNothing, 2)
+	if err != ErrBatchFailed {
+		t.Errorf("PayBatch(): must return ErrBatchFailed, returned = %v", err)
+		return
+	}
+	if report.Results[0].Err != ErrBatchAborted || report.Results[1].Err != ErrNotEnoughBalance || len(s.payments) != 0 {
+		t.Errorf("PayBatch(): results = %v, payments = %d", report.Results, len(s.payments))
+	}
+
+	// the second payment is denied while running, the first is rolled back
+	s.SetRiskChecks(func(request RiskRequest) RiskVerdict {
+		if len(request.Payments) > 0 {
+			return RiskVerdict{Outcome: types.RiskDeny, Rule: "once"}
+		}
+		return RiskVerdict{}
+	})
+	items[1].Amount = 300
+	report, err = s.PayBatch(context.Background(), items, BatchAllOrNothing, 2)
+	if err != ErrBatchFailed {
+		t.Errorf("PayBatch(): must return ErrBatchFailed, returned = %v", err)
+		return
+	}
+	if report.Results[1].Err != ErrPaymentDenied || report.Results[0].Payment == nil || report.Results[0].Payment.Status != types.PaymentStatusFail {
+		t.Errorf("PayBatch(): results = %v", report.Results)
+	}
+	if account.Balance != 1_000 {
+		t.Errorf("PayBatch(): rolled back balance = %d", account.Balance)
+	}
+	if _, err := s.FindBatch(report.ID); err != ErrBatchNotFound {
+		t.Errorf("FindBatch(): failed batch must not be kept, error = %v", err)
+	}
+
+	// a payment held for review fails the batch like a denial
+	s.SetRiskChecks(func(request RiskRequest) RiskVerdict {
+		if request.Amount == 300 {
+			return RiskVerdict{Outcome: types.RiskReview, Rule: "review"}
+		}
+		return RiskVerdict{}
+	})
+	report, err = s.PayBatch(context.Background(), items, BatchAllOrNothing, 2)
+	if err != ErrBatchFailed {
+		t.Errorf("PayBatch(): must return ErrBatchFailed, returned = %v", err)
+		return
+	}
+	if report.Results[1].Err != ErrBatchHeld || report.Results[1].Payment.Status != types.PaymentStatusFail || account.Balance != 1_000 {
+		t.Errorf("PayBatch(): results = %v, balance = %d", report.Results, account.Balance)
+	}
+
+	s.SetRiskChecks()
+	report, err = s.PayBatch(context.Background(), items, BatchAllOrNothing, 2)
+	if err != nil || report.Paid != 2 || account.Balance != 100 {
+		t.Errorf("PayBatch(): report = %v, error = %v, balance = %d", report, err, account.Balance)
+		return
+	}
+
+	_, err = s.FavoritePayment(report.Results[0].Payment.ID, "payroll")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Errorf("Export(): error = %v", err)
+		return
+	}
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+	batch, err := imported.FindBatch(report.ID)
+	if err != nil || batch.Mode != string(BatchAllOrNothing) || len(batch.PaymentIDs) != 2 {
+		t.Errorf("Import(): batch = %v, error = %v", batch, err)
+	}
+}
+
+func TestService_rollbackBatch(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992985570302", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rejected, err := s.Pay(1, 100, "salary")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Reject(rejected.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	paid, err := s.Pay(1, 200, "salary")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	results := []BatchResult{{Payment: rejected}, {Payment: paid}, {Err: ErrNotEnoughBalance}}
+	err = s.rollbackBatch(results)
+	if err != ErrBatchRollbackFailed {
+		t.Errorf("rollbackBatch(): must return ErrBatchRollbackFailed, returned = %v", err)
+	}
+	if !errors.Is(results[0].Err, ErrBatchRollbackFailed) || results[1].Err != nil || account.Balance != 1_000 {
+		t.Errorf("rollbackBatch(): results = %v, balance = %d", results, account.Balance)
+	}
+}
+
+// TestService_PayBatch_accounts runs many accounts on several workers, run
+// it with -race.
+func TestService_PayBatch_accounts(t *testing.T) {
+	s := newTestService()
+	var items []BatchItem
+	for i := 0; i < 20; i++ {
+		phone := types.Phone(fmt.Sprintf("+9929000000%02d", i))
+		_, err := s.addAccountWithBalance(phone, 10_000)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	for round := 0; round < 5; round++ {
+		for id := int64(1); id <= 20; id++ {
+			items = append(items, BatchItem{AccountID: id, Amount: types.Money(round+1) * 100, Category: "salary"})
+		}
+	}
+
+	report, err := s.PayBatch(context.Background(), items, BatchAllOrNothing, 8)
+	if err != nil || report.Paid != len(items) {
+		t.Errorf("PayBatch(): report = %v, error = %v", report, err)
+		return
+	}
+	for id := int64(1); id <= 20; id++ {
+		account, err := s.FindAccountByID(id)
+		if err != nil || account.Balance != 10_000-1_500 {
+			t.Errorf("PayBatch(): account %d = %v, error = %v", id, account, err)
+		}
+	}
+	// each account's payments keep the item order
+	for i, result := range report.Results {
+		if result.Payment == nil || result.Payment.Amount != items[i].Amount || result.Payment.AccountID != items[i].AccountID {
+			t.Errorf("PayBatch(): result %d = %v", i, result)
+		}
+	}
+
+	// a denial on one account rolls back every account
+	s.SetRiskChecks(func(request RiskRequest) RiskVerdict {
+		if request.Account.ID == 7 && request.Amount == 500 {
+			return RiskVerdict{Outcome: types.RiskDeny, Rule: "once"}
+		}
+		return RiskVerdict{}
+	})
+	report, err = s.PayBatch(context.Background(), items, BatchAllOrNothing, 8)
+	if err != ErrBatchFailed {
+		t.Errorf("PayBatch(): must return ErrBatchFailed, returned = %v", err)
+		return
+	}
+	for id := int64(1); id <= 20; id++ {
+		account, err := s.FindAccountByID(id)
+		if err != nil || account.Balance != 10_000-1_500 {
+			t.Errorf("PayBatch(): rolled back account %d = %v, error = %v", id, account, err)
+		}
+	}
+}
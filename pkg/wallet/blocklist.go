@@ -0,0 +1,217 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/adheeeem/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidBlocklist = errors.New("invalid blocklist")
+var ErrBlockedPhone = errors.New("phone is blocked")
+var ErrBlockedCategory = errors.New("category is blocked")
+
+// Blocklist lists the phones and merchant categories compliance doesn't
+// allow. In CSV every row is a kind ("phone" or "category") and a value; in
+// JSON it is an object with "phones" and "categories" arrays.
+type Blocklist struct {
+	Phones     []types.Phone           `json:"phones"`
+	Categories []types.PaymentCategory `json:"categories"`
+}
+
+func ReadBlocklistFile(path string) (Blocklist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Print(err)
+		return Blocklist{}, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return ReadBlocklistJSON(file)
+	}
+	return ReadBlocklistCSV(file)
+}
+
+func ReadBlocklistJSON(r io.Reader) (Blocklist, error) {
+	var list Blocklist
+	err := json.NewDecoder(r).Decode(&list)
+	if err != nil {
+		return Blocklist{}, fmt.Errorf("%w: %v", ErrInvalidBlocklist, err)
+	}
+	return list, nil
+}
+
+func ReadBlocklistCSV(r io.Reader) (Blocklist, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	var list Blocklist
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Blocklist{}, fmt.Errorf("%w: %v", ErrInvalidBlocklist, err)
+		}
+		value := strings.TrimSpace(row[1])
+		switch strings.ToLower(strings.TrimSpace(row[0])) {
+		case "phone":
+			list.Phones = append(list.Phones, types.Phone(value))
+		case "category":
+			list.Categories = append(list.Categories, types.PaymentCategory(value))
+		case "kind", "type":
+			if line == 1 {
+				continue
+			}
+			fallthrough
+		default:
+			return Blocklist{}, fmt.Errorf("%w: unknown kind %q on line %d", ErrInvalidBlocklist, row[0], line)
+		}
+	}
+	return list, nil
+}
+
+// SetBlocklist replaces the blocklist and screens every existing account
+// against it, returning the new matches. An account an earlier re-screening
+// already reported under the same phone is still blocked but isn't reported
+// again, so reloading the list doesn't repeat its matches.
+func (s *Service) SetBlocklist(list Blocklist) (matches []types.ScreeningMatch, err error) {
+	defer func() {
+		s.record("SetBlocklist", auditArgs("phones", len(list.Phones), "categories", len(list.Categories), "matches", len(matches)), "", err)
+	}()
+
+	phones := make(map[types.Phone]bool, len(list.Phones))
+	for _, phone := range list.Phones {
+		normalized, err := NormalizePhone(phone, s.countryCode())
+		if err != nil {
+			return nil, fmt.Errorf("%w: phone %q", ErrInvalidBlocklist, phone)
+		}
+		phones[normalized] = true
+	}
+	categories := make(map[types.PaymentCategory]bool, len(list.Categories))
+	for _, category := range list.Categories {
+		if category == "" {
+			return nil, fmt.Errorf("%w: empty category", ErrInvalidBlocklist)
+		}
+		categories[category] = true
+	}
+	s.blockedPhones = phones
+	s.blockedCategories = categories
+
+	for _, account := range s.accounts {
+		if !s.phoneBlocked(account.Phone) || s.rescreened(account) {
+			continue
+		}
+		match := s.screeningMatch(account.ID, account.Phone, "", "Rescreen")
+		matches = append(matches, *match)
+	}
+	return matches, nil
+}
+
+// LoadBlocklist reads the blocklist from a local CSV or JSON file, by its
+// extension, and applies it with SetBlocklist.
+func (s *Service) LoadBlocklist(path string) ([]types.ScreeningMatch, error) {
+	list, err := ReadBlocklistFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return s.SetBlocklist(list)
+}
+
+func (s *Service) phoneBlocked(phone types.Phone) bool {
+	return s.blockedPhones[s.phoneKey(phone)]
+}
+
+// rescreened reports whether the account was already reported by an earlier
+// re-screening, so loading the same list daily doesn't repeat the match.
+func (s *Service) rescreened(account *types.Account) bool {
+	for _, match := range s.screeningMatches {
+		if match.AccountID == account.ID && match.Operation == "Rescreen" && match.Phone == account.Phone {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) screeningMatch(accountID int64, phone types.Phone, category types.PaymentCategory, operation string) *types.ScreeningMatch {
+	match := &types.ScreeningMatch{
+		ID:        uuid.New().String(),
+		AccountID: accountID,
+		Phone:     phone,
+		Category:  category,
+		Operation: operation,
+		Created:   s.clock().UTC(),
+	}
+	s.screeningMatches = append(s.screeningMatches, match)
+	return match
+}
+
+// screenPayment blocks payments from blocked phones and to blocked categories.
+func (s *Service) screenPayment(account *types.Account, category types.PaymentCategory) error {
+	if s.phoneBlocked(account.Phone) {
+		s.screeningMatch(account.ID, account.Phone, "", "Pay")
+		return ErrBlockedPhone
+	}
+	if s.blockedCategories[category] {
+		s.screeningMatch(account.ID, "", category, "Pay")
+		return ErrBlockedCategory
+	}
+	return nil
+}
+
+// ScreeningReport returns every blocklist match: refused registrations and
+// payments and existing accounts found when a list was loaded.
+func (s *Service) ScreeningReport() []types.ScreeningMatch {
+	matches := make([]types.ScreeningMatch, len(s.screeningMatches))
+	for i, match := range s.screeningMatches {
+		matches[i] = *match
+	}
+	return matches
+}
+
+func screeningRows(matches []*types.ScreeningMatch) [][]string {
+	rows := make([][]string, len(matches))
+	for i, match := range matches {
+		rows[i] = []string{
+			match.ID,
+			strconv.FormatInt(match.AccountID, 10),
+			string(match.Phone),
+			string(match.Category),
+			match.Operation,
+			formatTime(match.Created),
+		}
+	}
+	return rows
+}
+
+func parseScreeningMatches(rows [][]string) []*types.ScreeningMatch {
+	matches := make([]*types.ScreeningMatch, 0, len(rows))
+	for _, row := range rows {
+		accID, _ := strconv.ParseInt(dumpField(row, 1), 10, 64)
+		matches = append(matches, &types.ScreeningMatch{
+			ID:        row[0],
+			AccountID: accID,
+			Phone:     types.Phone(dumpField(row, 2)),
+			Category:  types.PaymentCategory(dumpField(row, 3)),
+			Operation: dumpField(row, 4),
+			Created:   parseTime(dumpField(row, 5)),
+		})
+	}
+	return matches
+}
@@ -0,0 +1,134 @@
+package wallet
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestReadBlocklistCSV(t *testing.T) {
+	list, err := ReadBlocklistCSV(strings.NewReader("kind,value\nphone,985 57 03 03\ncategory,casino\n"))
+	if err != nil {
+		t.Errorf("ReadBlocklistCSV(): error = %v", err)
+		return
+	}
+	if len(list.Phones) != 1 || list.Phones[0] != "985 57 03 03" || len(list.Categories) != 1 || list.Categories[0] != "casino" {
+		t.Errorf("ReadBlocklistCSV(): got %v", list)
+	}
+
+	_, err = ReadBlocklistCSV(strings.NewReader("phone,985570303\nemail,x@example.com\n"))
+	if err == nil {
+		t.Error("ReadBlocklistCSV(): must return error for unknown kind")
+	}
+}
+
+func TestService_LoadBlocklist(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992985570302", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.addAccountWithBalance("+992985570303", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	path := filepath.Join(t.TempDir(), "blocklist.json")
+	err = os.WriteFile(path, []byte(`{"phones": ["985570302", "+992985570304"], "categories": ["casino"]}`), 0o600)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	matches, err := s.LoadBlocklist(path)
+	if err != nil {
+		t.Errorf("LoadBlocklist(): error = %v", err)
+		return
+	}
+	if len(matches) != 1 || matches[0].AccountID != account.ID || matches[0].Operation != "Rescreen" {
+		t.Errorf("LoadBlocklist(): matches = %v", matches)
+	}
+	matches, err = s.LoadBlocklist(path)
+	if err != nil || len(matches) != 0 {
+		t.Errorf("LoadBlocklist(): reload must not repeat matches, matches = %v, error = %v", matches, err)
+	}
+
+	_, err = s.RegisterAccount("+992 985 57 03 04")
+	if err != ErrBlockedPhone {
+		t.Errorf("RegisterAccount(): must return ErrBlockedPhone, returned = %v", err)
+	}
+	_, err = s.Pay(account.ID, 100, "auto")
+	if err != ErrBlockedPhone {
+		t.Errorf("Pay(): must return ErrBlockedPhone, returned = %v", err)
+	}
+	_, err = s.Pay(2, 100, "casino")
+	if err != ErrBlockedCategory {
+		t.Errorf("Pay(): must return ErrBlockedCategory, returned = %v", err)
+	}
+	_, err = s.Pay(2, 100, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+	}
+	_, err = s.PayToPhone(2, "985570302", 100)
+	if err != ErrBlockedPhone {
+		t.Errorf("PayToPhone(): must return ErrBlockedPhone for a blocked recipient, returned = %v", err)
+	}
+	err = s.ChangePhone(2, "985570304")
+	if err != ErrBlockedPhone {
+		t.Errorf("ChangePhone(): must return ErrBlockedPhone, returned = %v", err)
+	}
+
+	report := s.ScreeningReport()
+	want := []string{"Rescreen", "RegisterAccount", "Pay", "Pay", "Transfer", "ChangePhone"}
+	if len(report) != len(want) {
+		t.Errorf("ScreeningReport(): got %v", report)
+		return
+	}
+	for i, operation := range want {
+		if report[i].Operation != operation {
+			t.Errorf("ScreeningReport(): match %d = %v, want operation %s", i, report[i], operation)
+		}
+	}
+	if report[1].Phone != types.Phone("+992985570304") || report[3].Category != "casino" || report[4].AccountID != 2 || report[5].Phone != types.Phone("+992985570304") {
+		t.Errorf("ScreeningReport(): got %v", report)
+	}
+}
+
+func TestService_SetBlocklist_changed(t *testing.T) {
+	s := newTestService()
+	first, err := s.addAccountWithBalance("+992985570302", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.addAccountWithBalance("+992985570303", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	matches, err := s.SetBlocklist(Blocklist{Phones: []types.Phone{"+992985570302"}})
+	if err != nil || len(matches) != 1 || matches[0].AccountID != first.ID {
+		t.Errorf("SetBlocklist(): matches = %v, error = %v", matches, err)
+		return
+	}
+	// the second list adds an account and keeps the one already reported
+	matches, err = s.SetBlocklist(Blocklist{Phones: []types.Phone{"+992985570302", "+992985570303"}})
+	if err != nil || len(matches) != 1 || matches[0].AccountID != second.ID {
+		t.Errorf("SetBlocklist(): matches = %v, error = %v", matches, err)
+		return
+	}
+	for _, account := range []*types.Account{first, second} {
+		_, err = s.Pay(account.ID, 100, "auto")
+		if err != ErrBlockedPhone {
+			t.Errorf("Pay(): must return ErrBlockedPhone for account %d, returned = %v", account.ID, err)
+		}
+	}
+	if report := s.ScreeningReport(); len(report) != 4 {
+		t.Errorf("ScreeningReport(): got %v", report)
+	}
+}
@@ -0,0 +1,189 @@
+package wallet
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+var ErrCategoryNotFound = errors.New("category not found")
+var ErrCategoryExists = errors.New("category already exists")
+var ErrCategoryInactive = errors.New("category is inactive")
+var ErrInvalidCategory = errors.New("invalid category")
+
+type CategoryMode int
+
+const (
+	// CategoryModePermissive accepts any category and remembers the ones
+	// missing from the registry, see UnknownCategories.
+	CategoryModePermissive CategoryMode = iota
+	// CategoryModeStrict rejects unknown and inactive categories.
+	CategoryModeStrict
+)
+
+func (s *Service) SetCategoryMode(mode CategoryMode) {
+	defer func() {
+		s.record("SetCategoryMode", auditArgs("mode", mode), "", nil)
+	}()
+
+	s.categoryMode = mode
+}
+
+func (s *Service) AddCategory(category types.Category) (err error) {
+	defer func() {
+		s.record("AddCategory", auditArgs("code", category.Code, "name", category.Name, "parent", category.Parent, "active", category.Active), "", err)
+	}()
+
+	if category.Code == "" || category.Code == category.Parent {
+		return ErrInvalidCategory
+	}
+	if _, err := s.FindCategory(category.Code); err == nil {
+		return ErrCategoryExists
+	}
+	if category.Parent != "" {
+		if _, err := s.FindCategory(category.Parent); err != nil {
+			return err
+		}
+	}
+
+	s.categories = append(s.categories, &category)
+	return nil
+}
+
+func (s *Service) FindCategory(code types.PaymentCategory) (*types.Category, error) {
+	for _, category := range s.categories {
+		if category.Code == code {
+			return category, nil
+		}
+	}
+
+	return nil, ErrCategoryNotFound
+}
+
+func (s *Service) SetCategoryActive(code types.PaymentCategory, active bool) (err error) {
+	defer func() {
+		s.record("SetCategoryActive", auditArgs("code", code, "active", active), "", err)
+	}()
+
+	category, err := s.FindCategory(code)
+	if err != nil {
+		return err
+	}
+	category.Active = active
+	return nil
+}
+
+func (s *Service) Categories() []types.Category {
+	categories := make([]types.Category, len(s.categories))
+	for i, category := range s.categories {
+		categories[i] = *category
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].Code < categories[j].Code
+	})
+	return categories
+}
+
+// CategoryDescendants returns the code itself followed by all of its children,
+// grandchildren and so on, so reports can roll a subtree up into its parent.
+func (s *Service) CategoryDescendants(code types.PaymentCategory) ([]types.PaymentCategory, error) {
+	if _, err := s.FindCategory(code); err != nil {
+		return nil, err
+	}
+	result := []types.PaymentCategory{code}
+	for i := 0; i < len(result); i++ {
+		for _, category := range s.categories {
+			if category.Parent == result[i] {
+				result = append(result, category.Code)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (s *Service) validateCategory(code types.PaymentCategory) error {
+	if code == TransferCategory || chargeCategory(code) {
+		// transfers need a recipient and only go through PayToPhone, charges
+		// are only made by the wallet itself
+		return ErrInvalidCategory
+	}
+	category, err := s.FindCategory(code)
+	if s.categoryMode == CategoryModePermissive {
+		if err != nil {
+			if s.unknownCategories == nil {
+				s.unknownCategories = make(map[types.PaymentCategory]int)
+			}
+			s.unknownCategories[code]++
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !category.Active {
+		return ErrCategoryInactive
+	}
+	return nil
+}
+
+// UnknownCategories reports how many times each unregistered category was
+// used while the service ran in permissive mode.
+func (s *Service) UnknownCategories() map[types.PaymentCategory]int {
+	result := make(map[types.PaymentCategory]int, len(s.unknownCategories))
+	for code, count := range s.unknownCategories {
+		result[code] = count
+	}
+	return result
+}
+
+// RemapCategories rewrites legacy categories of payments and favorites, for
+// example after Import of old dumps, and returns how many records changed.
+func (s *Service) RemapCategories(mapping map[types.PaymentCategory]types.PaymentCategory) (changed int, err error) {
+	defer func() {
+		s.record("RemapCategories", auditArgs("mapping", mapping), "changed="+strconv.Itoa(changed), err)
+	}()
+
+	for _, to := range mapping {
+		if _, err := s.FindCategory(to); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, payment := range s.payments {
+		if to, ok := mapping[payment.Category]; ok && to != payment.Category {
+			payment.Category = to
+			changed++
+		}
+	}
+	for _, favorite := range s.favorites {
+		if to, ok := mapping[favorite.Category]; ok && to != favorite.Category {
+			favorite.Category = to
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+func categoryRows(categories []*types.Category) [][]string {
+	rows := make([][]string, len(categories))
+	for i, category := range categories {
+		rows[i] = []string{string(category.Code), category.Name, string(category.Parent), strconv.FormatBool(category.Active)}
+	}
+	return rows
+}
+
+func parseCategories(rows [][]string) []*types.Category {
+	categories := make([]*types.Category, 0, len(rows))
+	for _, row := range rows {
+		active, _ := strconv.ParseBool(dumpField(row, 3))
+		categories = append(categories, &types.Category{
+			Code:   types.PaymentCategory(row[0]),
+			Name:   dumpField(row, 1),
+			Parent: types.PaymentCategory(dumpField(row, 2)),
+			Active: active,
+		})
+	}
+	return categories
+}
@@ -0,0 +1,117 @@
+package wallet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func newServiceWithCategories(t *testing.T) *testService {
+	s := newTestService()
+	for _, category := range []types.Category{
+		{Code: "shopping", Name: "Shopping", Active: true},
+		{Code: "grocery", Name: "Grocery", Parent: "shopping", Active: true},
+		{Code: "clothes", Name: "Clothes", Parent: "shopping", Active: true},
+		{Code: "auto", Name: "Auto", Active: false},
+	} {
+		err := s.AddCategory(category)
+		if err != nil {
+			t.Fatalf("AddCategory(): error = %v", err)
+		}
+	}
+	return s
+}
+
+func TestService_AddCategory_fail(t *testing.T) {
+	s := newServiceWithCategories(t)
+	err := s.AddCategory(types.Category{Code: "grocery", Name: "Grocery"})
+	if err != ErrCategoryExists {
+		t.Errorf("AddCategory(): must return ErrCategoryExists, returned = %v", err)
+	}
+	err = s.AddCategory(types.Category{Code: "taxi", Parent: "transport"})
+	if err != ErrCategoryNotFound {
+		t.Errorf("AddCategory(): must return ErrCategoryNotFound, returned = %v", err)
+	}
+
+	got, err := s.CategoryDescendants("shopping")
+	if err != nil {
+		t.Errorf("CategoryDescendants(): error = %v", err)
+		return
+	}
+	want := []types.PaymentCategory{"shopping", "grocery", "clothes"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CategoryDescendants(): got %v, want %v", got, want)
+	}
+}
+
+func TestService_Pay_strictCategories(t *testing.T) {
+	s := newServiceWithCategories(t)
+	s.SetCategoryMode(CategoryModeStrict)
+	_, err := s.addAccountWithBalance("+992985570302", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.Pay(1, 100, "grocery")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+	}
+	_, err = s.Pay(1, 100, "grocey")
+	if err != ErrCategoryNotFound {
+		t.Errorf("Pay(): must return ErrCategoryNotFound, returned = %v", err)
+	}
+	_, err = s.Pay(1, 100, "auto")
+	if err != ErrCategoryInactive {
+		t.Errorf("Pay(): must return ErrCategoryInactive, returned = %v", err)
+	}
+	account, _ := s.FindAccountByID(1)
+	if account.Balance != 1_000_00-100 {
+		t.Errorf("Pay(): rejected payments changed balance, account = %v", account)
+	}
+}
+
+func TestService_Pay_permissiveCategories(t *testing.T) {
+	s := newServiceWithCategories(t)
+	_, err := s.addAccountWithBalance("+992985570302", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(1, 100, "grocey")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	if got := s.UnknownCategories(); got["grocey"] != 1 {
+		t.Errorf("UnknownCategories(): got %v", got)
+	}
+}
+
+func TestService_RemapCategories(t *testing.T) {
+	s := newServiceWithCategories(t)
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.FavoritePayment(payments[0].ID, "car")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.RemapCategories(map[types.PaymentCategory]types.PaymentCategory{"auto": "missing"})
+	if err != ErrCategoryNotFound {
+		t.Errorf("RemapCategories(): must return ErrCategoryNotFound, returned = %v", err)
+	}
+
+	changed, err := s.RemapCategories(map[types.PaymentCategory]types.PaymentCategory{"auto": "shopping"})
+	if err != nil {
+		t.Errorf("RemapCategories(): error = %v", err)
+		return
+	}
+	if changed != 2 || payments[0].Category != "shopping" || s.favorites[0].Category != "shopping" {
+		t.Errorf("RemapCategories(): changed = %d, payment = %v", changed, payments[0])
+	}
+}
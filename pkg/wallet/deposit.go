@@ -0,0 +1,273 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrDepositNotFound = errors.New("deposit not found")
+var ErrDepositReversed = errors.New("deposit already reversed")
+var ErrDepositNotReversible = errors.New("deposit can't be reversed")
+var ErrReservedSource = errors.New("deposit source is reserved")
+
+// ErrUnexplainedBalance is returned by Import for a dump without deposits in
+// which an account holds less than its payments leave.
+var ErrUnexplainedBalance = errors.New("balance below its history")
+
+// OpeningDepositSource marks the deposits Import adds for the balances of
+// dumps made before deposits were recorded.
+const OpeningDepositSource = "opening"
+
+func (s *Service) deposit(accountID int64, amount types.Money, source string) (*types.Deposit, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, ErrAccountNotFound
+	}
+	err = s.credit(account, amount)
+	if err != nil {
+		return nil, err
+	}
+	deposit := &types.Deposit{
+		ID:        uuid.New().String(),
+		AccountID: accountID,
+		Amount:    amount,
+		Source:    source,
+		Created:   s.clock().UTC(),
+	}
+	s.deposits = append(s.deposits, deposit)
+	return deposit, nil
+}
+
+// internalSource reports whether a deposit was made by the wallet itself:
+// transfers, vouchers, cashback and opening balances. Their money comes
+// from elsewhere in the wallet, so they are undone only with the operation
+// that made them.
+func internalSource(source string) bool {
+	switch {
+	case source == CashbackSource || source == OpeningDepositSource:
+		return true
+	case strings.HasPrefix(source, "p2p:") || strings.HasPrefix(source, "voucher:"):
+		return true
+	}
+	return false
+}
+
+func (s *Service) DepositFrom(accountID int64, amount types.Money, source string) (deposit *types.Deposit, err error) {
+	defer func() {
+		result := ""
+		if deposit != nil {
+			result = "deposit=" + deposit.ID
+		}
+		s.record("DepositFrom", auditArgs("account", accountID, "amount", amount, "source", source), result, err)
+	}()
+
+	if internalSource(source) {
+		return nil, ErrReservedSource
+	}
+	return s.deposit(accountID, amount, source)
+}
+
+func (s *Service) FindDepositByID(depositID string) (*types.Deposit, error) {
+	for _, deposit := range s.deposits {
+		if deposit.ID == depositID {
+			return deposit, nil
+		}
+	}
+
+	return nil, ErrDepositNotFound
+}
+
+// ReverseDeposit takes the deposited amount back, for example when the
+// incoming transfer is recalled by the bank. Internal deposits can't be
+// reversed: a transfer is undone by rejecting its payment.
+func (s *Service) ReverseDeposit(depositID string) (err error) {
+	defer func() {
+		s.record("ReverseDeposit", auditArgs("deposit", depositID), "", err)
+	}()
+
+	deposit, err := s.FindDepositByID(depositID)
+	if err != nil {
+		return err
+	}
+	if internalSource(deposit.Source) {
+		return ErrDepositNotReversible
+	}
+	if !deposit.Reversed.IsZero() {
+		return ErrDepositReversed
+	}
+	account, err := s.FindAccountByID(deposit.AccountID)
+	if err != nil {
+		return err
+	}
+	if available(account) < deposit.Amount {
+		return ErrNotEnoughBalance
+	}
+
+	err = s.debit(account, deposit.Amount)
+	if err != nil {
+		return err
+	}
+	deposit.Reversed = s.clock().UTC()
+	return nil
+}
+
+func (s *Service) AccountTransactions(accountID int64) ([]LedgerEntry, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	return s.ledger(accountID), nil
+}
+
+// openingDeposits records the part of each balance the history doesn't
+// explain as an opening deposit dated at the account's earliest activity, so
+// it sorts first in the ledger. A balance below what the history explains
+// can't be opened with a deposit and is reported as ErrUnexplainedBalance.
+func (s *Service) openingDeposits(accounts []*types.Account) error {
+	for _, account := range accounts {
+		ledger := s.ledger(account.ID)
+		expected := types.Money(0)
+		if len(ledger) > 0 {
+			expected = ledger[len(ledger)-1].Balance
+		}
+		if account.Balance == expected {
+			continue
+		}
+		if account.Balance < expected {
+			return fmt.Errorf("%w: account %d has %d, history explains %d", ErrUnexplainedBalance, account.ID, account.Balance, expected)
+		}
+		s.deposits = append(s.deposits, &types.Deposit{
+			ID:        uuid.New().String(),
+			AccountID: account.ID,
+			Amount:    account.Balance - expected,
+			Source:    OpeningDepositSource,
+			Created:   s.openedAt(account, ledger),
+		})
+	}
+	return nil
+}
+
+// openedAt returns the time of the account's earliest dated activity, its
+// creation time if there is none, and the current time for accounts dumped
+// without either.
+func (s *Service) openedAt(account *types.Account, ledger []LedgerEntry) time.Time {
+	opened := account.Created
+	// the ledger is in time order, so its first dated entry is the earliest
+	for _, entry := range ledger {
+		if entry.Time.IsZero() {
+			continue
+		}
+		if opened.IsZero() || entry.Time.Before(opened) {
+			opened = entry.Time
+		}
+		break
+	}
+	if opened.IsZero() {
+		return s.clock().UTC()
+	}
+	return opened
+}
+
+// ExportAccountDeposits returns the account's deposits, the counterpart of
+// ExportAccountHistory, for DepositsToFiles.
+func (s *Service) ExportAccountDeposits(accountID int64) ([]types.Deposit, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	var deposits []types.Deposit
+	for _, deposit := range s.deposits {
+		if deposit.AccountID == accountID {
+			deposits = append(deposits, *deposit)
+		}
+	}
+	return deposits, nil
+}
+
+// DepositsToFiles writes the deposits like HistoryToFiles writes payments:
+// deposits.dump, or deposits1.dump, deposits2.dump... of at most records
+// deposits each. The files use the deposits.dump format of Export.
+func (s *Service) DepositsToFiles(deposits []types.Deposit, dir string, records int) error {
+	rows := make([]*types.Deposit, len(deposits))
+	for i := range deposits {
+		rows[i] = &deposits[i]
+	}
+	if len(rows) <= records {
+		return writeRecords(dir+"/deposits.dump", depositRows(rows))
+	}
+	for i, part := range chunks(len(rows), records) {
+		err := writeRecords(fmt.Sprintf("%s/deposits%d.dump", dir, i+1), depositRows(rows[part.from:part.to]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type BalanceMismatch struct {
+	AccountID int64
+	Balance   types.Money
+	Expected  types.Money
+}
+
+// VerifyBalances recomputes every balance from deposits, payments and refunds
+// and returns the accounts whose stored balance doesn't match.
+func (s *Service) VerifyBalances() []BalanceMismatch {
+	var mismatches []BalanceMismatch
+	for _, account := range s.accounts {
+		expected := types.Money(0)
+		if ledger := s.ledger(account.ID); len(ledger) > 0 {
+			expected = ledger[len(ledger)-1].Balance
+		}
+		if expected != account.Balance {
+			mismatches = append(mismatches, BalanceMismatch{
+				AccountID: account.ID,
+				Balance:   account.Balance,
+				Expected:  expected,
+			})
+		}
+	}
+	return mismatches
+}
+
+func depositRows(deposits []*types.Deposit) [][]string {
+	rows := make([][]string, len(deposits))
+	for i, deposit := range deposits {
+		rows[i] = []string{
+			deposit.ID,
+			strconv.FormatInt(int64(deposit.Amount), 10),
+			deposit.Source,
+			strconv.FormatInt(deposit.AccountID, 10),
+			formatTime(deposit.Created),
+			formatTime(deposit.Reversed),
+		}
+	}
+	return rows
+}
+
+func parseDeposits(rows [][]string) []*types.Deposit {
+	deposits := make([]*types.Deposit, 0, len(rows))
+	for _, row := range rows {
+		amount, _ := strconv.ParseInt(dumpField(row, 1), 10, 64)
+		accID, _ := strconv.ParseInt(dumpField(row, 3), 10, 64)
+		deposits = append(deposits, &types.Deposit{
+			ID:        row[0],
+			Amount:    types.Money(amount),
+			Source:    dumpField(row, 2),
+			AccountID: accID,
+			Created:   parseTime(dumpField(row, 4)),
+			Reversed:  parseTime(dumpField(row, 5)),
+		})
+	}
+	return deposits
+}
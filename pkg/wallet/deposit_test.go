@@ -0,0 +1,293 @@
+package wallet
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestService_DepositFrom_history(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	deposit, err := s.DepositFrom(1, 500, "bank transfer")
+	if err != nil {
+		t.Errorf("DepositFrom(): error = %v", err)
+		return
+	}
+
+	entries, err := s.AccountTransactions(1)
+	if err != nil {
+		t.Errorf("AccountTransactions(): error = %v", err)
+		return
+	}
+	if len(entries) != 3 {
+		t.Errorf("AccountTransactions(): got %d entries, want %d", len(entries), 3)
+		return
+	}
+	if entries[1].Reference != payments[0].ID || entries[2].Reference != deposit.ID || entries[2].Source != "bank transfer" {
+		t.Errorf("AccountTransactions(): wrong entries = %v", entries)
+	}
+	if mismatches := s.VerifyBalances(); len(mismatches) != 0 {
+		t.Errorf("VerifyBalances(): got mismatches = %v", mismatches)
+	}
+}
+
+func TestService_Deposit_overflow(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992985570302", types.MaxMoney-1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.Deposit(account.ID, 1)
+	if err != nil {
+		t.Errorf("Deposit(): error = %v", err)
+	}
+	err = s.Deposit(account.ID, 1)
+	if !errors.Is(err, types.ErrMoneyOverflow) {
+		t.Errorf("Deposit(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+	if account.Balance != types.MaxMoney || len(s.deposits) != 2 {
+		t.Errorf("Deposit(): balance = %d, deposits = %v", account.Balance, s.deposits)
+	}
+}
+
+func TestService_ReverseDeposit(t *testing.T) {
+	s := newTestService()
+	_, err := s.RegisterAccount("+992985570302")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	deposit, err := s.DepositFrom(1, 500, "card")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ReverseDeposit(deposit.ID)
+	if err != nil {
+		t.Errorf("ReverseDeposit(): error = %v", err)
+		return
+	}
+	err = s.ReverseDeposit(deposit.ID)
+	if err != ErrDepositReversed {
+		t.Errorf("ReverseDeposit(): must return ErrDepositReversed, returned = %v", err)
+	}
+	account, _ := s.FindAccountByID(1)
+	if account.Balance != 0 {
+		t.Errorf("ReverseDeposit(): balance didn't change, account = %v", account)
+	}
+	if mismatches := s.VerifyBalances(); len(mismatches) != 0 {
+		t.Errorf("VerifyBalances(): got mismatches = %v", mismatches)
+	}
+
+	second, err := s.DepositFrom(1, 500, "card")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(1, 400, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ReverseDeposit(second.ID)
+	if err != ErrNotEnoughBalance {
+		t.Errorf("ReverseDeposit(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+}
+
+func TestService_ExportImport_deposits(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.FavoritePayment(payments[0].ID, "car")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Errorf("Export(): error = %v", err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+	if len(imported.deposits) != 1 || imported.deposits[0].Amount != defaultTestAccount.balance {
+		t.Errorf("Import(): wrong deposits = %v", imported.deposits)
+	}
+	if mismatches := imported.VerifyBalances(); len(mismatches) != 0 {
+		t.Errorf("VerifyBalances(): got mismatches = %v", mismatches)
+	}
+}
+
+func TestService_Import_preLedgerDump(t *testing.T) {
+	s := newTestService()
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.FavoritePayment(payments[0].ID, "car")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Errorf("Export(): error = %v", err)
+		return
+	}
+	// dumps made before deposits were recorded have no deposits.dump
+	err = os.Remove(dir + "/deposits.dump")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+	deposits, err := imported.ExportAccountDeposits(1)
+	if err != nil || len(deposits) != 1 || deposits[0].Source != OpeningDepositSource || deposits[0].Amount != defaultTestAccount.balance {
+		t.Errorf("Import(): opening deposits = %v, error = %v", deposits, err)
+		return
+	}
+	// dated at the account's earliest activity, its registration
+	account, err := s.FindAccountByID(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !deposits[0].Created.Equal(account.Created) {
+		t.Errorf("Import(): opening deposit created = %v, want %v", deposits[0].Created, account.Created)
+	}
+	if mismatches := imported.VerifyBalances(); len(mismatches) != 0 {
+		t.Errorf("VerifyBalances(): got mismatches = %v", mismatches)
+	}
+
+	page, err := imported.AccountHistoryPage(1, HistoryQuery{})
+	// the opening deposit sorts first
+	if err != nil || len(page.Entries) != 2 || page.Entries[0].Kind != EntryDeposit || page.Entries[1].Kind != EntryPayment {
+		t.Errorf("AccountHistoryPage(): page = %v, error = %v", page, err)
+	}
+
+	out := t.TempDir()
+	err = imported.DepositsToFiles(deposits, out, 10)
+	if err != nil {
+		t.Errorf("DepositsToFiles(): error = %v", err)
+		return
+	}
+	rows, err := readRecords(out + "/deposits.dump")
+	if err != nil || len(parseDeposits(rows)) != 1 {
+		t.Errorf("DepositsToFiles(): rows = %v, error = %v", rows, err)
+	}
+}
+
+func TestService_Import_preLedgerDumpUnexplained(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 60, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.FavoritePayment(payment.ID, "car")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// the payments alone leave -60, less is left than they explain
+	account.Balance = -100
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Errorf("Export(): error = %v", err)
+		return
+	}
+	err = os.Remove(dir + "/deposits.dump")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = newTestService().Import(dir)
+	if !errors.Is(err, ErrUnexplainedBalance) {
+		t.Errorf("Import(): error = %v, want %v", err, ErrUnexplainedBalance)
+	}
+}
+
+func TestService_ReverseDeposit_internal(t *testing.T) {
+	s := newTestService()
+	payer, err := s.addAccountWithBalance("+992985570302", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	recipient, err := s.RegisterAccount("+992900000000")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.PayToPhone(payer.ID, recipient.Phone, 400)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	deposits, err := s.ExportAccountDeposits(recipient.ID)
+	if err != nil || len(deposits) != 1 {
+		t.Errorf("ExportAccountDeposits(): deposits = %v, error = %v", deposits, err)
+		return
+	}
+
+	err = s.ReverseDeposit(deposits[0].ID)
+	if err != ErrDepositNotReversible {
+		t.Errorf("ReverseDeposit(): must return ErrDepositNotReversible, returned = %v", err)
+	}
+	if recipient.Balance != 400 {
+		t.Errorf("ReverseDeposit(): recipient balance = %d", recipient.Balance)
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Errorf("Reject(): error = %v", err)
+		return
+	}
+	if payer.Balance != 1_000 || recipient.Balance != 0 {
+		t.Errorf("Reject(): balances = %d, %d", payer.Balance, recipient.Balance)
+	}
+
+	_, err = s.DepositFrom(recipient.ID, 100, "p2p:"+payment.ID)
+	if err != ErrReservedSource {
+		t.Errorf("DepositFrom(): must return ErrReservedSource, returned = %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func fromUnixNano(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos).UTC()
+}
+
+func parseTime(value string) time.Time {
+	nanos, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return fromUnixNano(nanos)
+}
+
+// dumpField returns the i-th field of a dump line, or "" for lines written
+// before the field was added to the format.
+func dumpField(data []string, i int) string {
+	if i >= len(data) {
+		return ""
+	}
+	return data[i]
+}
+
+func formatAccount(account *types.Account) string {
+	return strings.Join([]string{
+		strconv.FormatInt(account.ID, 10),
+		string(account.Phone),
+		strconv.FormatInt(int64(account.Balance), 10),
+		strconv.FormatInt(int64(account.CreditLimit), 10),
+		strconv.FormatInt(account.OverdraftRate, 10),
+		formatTime(account.OverdrawnSince),
+		formatTime(account.InterestAccrued),
+		strconv.FormatInt(int64(account.Cashback), 10),
+		formatTime(account.Created),
+		strconv.FormatInt(account.UnchargedInterest, 10),
+	}, ";")
+}
+
+func parseAccount(line string) *types.Account {
+	data := strings.Split(strings.TrimRight(line, "\r\n"), ";")
+	id, _ := strconv.ParseInt(data[0], 10, 64)
+	balance, _ := strconv.ParseInt(dumpField(data, 2), 10, 64)
+	limit, _ := strconv.ParseInt(dumpField(data, 3), 10, 64)
+	rate, _ := strconv.ParseInt(dumpField(data, 4), 10, 64)
+	cashback, _ := strconv.ParseInt(dumpField(data, 7), 10, 64)
+	interest, _ := strconv.ParseInt(dumpField(data, 9), 10, 64)
+	return &types.Account{
+		ID:              id,
+		Phone:           types.Phone(dumpField(data, 1)),
+		Balance:         types.Money(balance),
+		CreditLimit:     types.Money(limit),
+		OverdraftRate:   rate,
+		OverdrawnSince:  parseTime(dumpField(data, 5)),
+		InterestAccrued: parseTime(dumpField(data, 6)),
+		Cashback:        types.Money(cashback),
+		Created:         parseTime(dumpField(data, 8)),
+
+		UnchargedInterest: interest,
+	}
+}
+
+func formatPayment(payment *types.Payment) string {
+	return strings.Join([]string{
+		payment.ID,
+		strconv.Itoa(int(payment.Amount)),
+		string(payment.Category),
+		string(payment.Status),
+		strconv.Itoa(int(payment.AccountID)),
+		formatTime(payment.Created),
+		formatTime(payment.Rejected),
+		strconv.FormatInt(payment.RecipientID, 10),
+	}, ";")
+}
+
+func parsePayment(line string) *types.Payment {
+	data := strings.Split(strings.TrimRight(line, "\r\n"), ";")
+	amount, _ := strconv.Atoi(dumpField(data, 1))
+	accID, _ := strconv.ParseInt(dumpField(data, 4), 10, 64)
+	recipientID, _ := strconv.ParseInt(dumpField(data, 7), 10, 64)
+	return &types.Payment{
+		ID:          data[0],
+		Amount:      types.Money(amount),
+		Category:    types.PaymentCategory(dumpField(data, 2)),
+		Status:      types.PaymentStatus(dumpField(data, 3)),
+		AccountID:   accID,
+		Created:     parseTime(dumpField(data, 5)),
+		Rejected:    parseTime(dumpField(data, 6)),
+		RecipientID: recipientID,
+	}
+}
+
+// writeRecords stores free-text tables such as names and sources, where a bare
+// ";" split would break, as ";"-separated CSV.
+func writeRecords(path string, rows [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	writer := csv.NewWriter(file)
+	writer.Comma = ';'
+	err = writer.WriteAll(rows)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+// readRecords returns nil rows if the optional dump file doesn't exist.
+func readRecords(path string) ([][]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	reader := csv.NewReader(file)
+	reader.Comma = ';'
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	return rows, nil
+}
@@ -240,8 +240,8 @@ func BenchmarkService_SumPayments(b *testing.B) {
 
 	want := types.Money(11121)
 	for i := 0; i < b.N; i++ {
-		result := s.SumPayments(2)
-		if result != want {
+		result, err := s.SumPayments(2)
+		if err != nil || result != want {
 			b.Fatalf("invalid result, got %v, want %v", result, want)
 		}
 	}
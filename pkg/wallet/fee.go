@@ -0,0 +1,240 @@
+package wallet
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/adheeeem/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidFeeRule = errors.New("invalid fee rule")
+
+// FeeTier applies to payments up to UpTo inclusive; UpTo 0 means no upper bound.
+type FeeTier struct {
+	UpTo    types.Money
+	Flat    types.Money
+	Percent int64
+}
+
+// FeeRule charges Flat plus Percent basis points of the payment amount. If
+// Tiers are set, the first tier covering the amount replaces Flat and Percent.
+// The result is kept between Min and Max, Max 0 meaning no cap. A rule with
+// an empty Category applies to every category without its own rule.
+type FeeRule struct {
+	Category types.PaymentCategory
+	Flat     types.Money
+	Percent  int64
+	Tiers    []FeeTier
+	Min      types.Money
+	Max      types.Money
+}
+
+// Fee returns ErrMoneyOverflow if the percentage of amount doesn't fit Money.
+func (r FeeRule) Fee(amount types.Money) (types.Money, error) {
+	flat, percent := r.Flat, r.Percent
+	for _, tier := range r.Tiers {
+		if tier.UpTo == 0 || amount <= tier.UpTo {
+			flat, percent = tier.Flat, tier.Percent
+			break
+		}
+	}
+
+	fee, err := amount.Mul(percent)
+	if err != nil {
+		return 0, err
+	}
+	fee, err = flat.Add(fee / basisPoints)
+	if err != nil {
+		return 0, err
+	}
+	if fee < r.Min {
+		fee = r.Min
+	}
+	if r.Max > 0 && fee > r.Max {
+		fee = r.Max
+	}
+	return fee, nil
+}
+
+func (r FeeRule) valid() bool {
+	if r.Flat < 0 || r.Percent < 0 || r.Min < 0 || r.Max < 0 || (r.Max > 0 && r.Min > r.Max) {
+		return false
+	}
+	for i, tier := range r.Tiers {
+		if tier.Flat < 0 || tier.Percent < 0 || tier.UpTo < 0 {
+			return false
+		}
+		if i > 0 && (r.Tiers[i-1].UpTo == 0 || tier.UpTo != 0 && tier.UpTo <= r.Tiers[i-1].UpTo) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetFeeSchedule replaces all fee rules. Collected fees are credited to the
+// revenue account.
+func (s *Service) SetFeeSchedule(revenueAccountID int64, rules []FeeRule) (err error) {
+	defer func() {
+		s.record("SetFeeSchedule", auditArgs("revenue", revenueAccountID, "rules", rules), "", err)
+	}()
+
+	_, err = s.FindAccountByID(revenueAccountID)
+	if err != nil {
+		return err
+	}
+	schedule := make(map[types.PaymentCategory]FeeRule, len(rules))
+	for _, rule := range rules {
+		if _, ok := schedule[rule.Category]; ok || !rule.valid() {
+			return ErrInvalidFeeRule
+		}
+		schedule[rule.Category] = rule
+	}
+
+	s.feeRules = schedule
+	s.revenueAccountID = revenueAccountID
+	return nil
+}
+
+func (s *Service) feeFor(account *types.Account, category types.PaymentCategory, amount types.Money) (types.Money, error) {
+	if len(s.feeRules) == 0 || account.ID == s.revenueAccountID {
+		return 0, nil
+	}
+	rule, ok := s.feeRules[category]
+	if !ok {
+		rule, ok = s.feeRules[""]
+	}
+	if !ok {
+		return 0, nil
+	}
+	return rule.Fee(amount)
+}
+
+func (s *Service) chargeFee(account *types.Account, payment *types.Payment, amount types.Money) error {
+	revenue, err := s.FindAccountByID(s.revenueAccountID)
+	if err != nil {
+		return nil
+	}
+	if _, err := revenue.Balance.Add(amount); err != nil {
+		return err
+	}
+	err = s.debit(account, amount)
+	if err != nil {
+		return err
+	}
+	err = s.credit(revenue, amount)
+	if err != nil {
+		return err
+	}
+	s.fees = append(s.fees, &types.Fee{
+		ID:               uuid.New().String(),
+		PaymentID:        payment.ID,
+		AccountID:        account.ID,
+		RevenueAccountID: revenue.ID,
+		Amount:           amount,
+		Created:          s.clock().UTC(),
+	})
+	return nil
+}
+
+// feeRefund returns the payment's fee and the part of it proportional to the
+// refunded amount. The fee is nil when there is nothing to refund.
+func (s *Service) feeRefund(payment *types.Payment, refunded types.Money) (*types.Fee, types.Money, error) {
+	for _, fee := range s.fees {
+		if fee.PaymentID != payment.ID {
+			continue
+		}
+		amount, err := fee.Amount.Mul(int64(refunded))
+		if err != nil {
+			return nil, 0, err
+		}
+		amount /= payment.Amount
+		if amount > fee.Amount-fee.Refunded {
+			amount = fee.Amount - fee.Refunded
+		}
+		if amount <= 0 {
+			return nil, 0, nil
+		}
+		return fee, amount, nil
+	}
+	return nil, 0, nil
+}
+
+// refundFee returns the part of the payment's fee proportional to the
+// refunded amount, taking it back from the revenue account.
+func (s *Service) refundFee(payment *types.Payment, refunded types.Money) error {
+	fee, amount, err := s.feeRefund(payment, refunded)
+	if err != nil || fee == nil {
+		return err
+	}
+	account, err := s.FindAccountByID(fee.AccountID)
+	if err != nil {
+		return nil
+	}
+	revenue, err := s.FindAccountByID(fee.RevenueAccountID)
+	if err != nil {
+		return nil
+	}
+	if _, err := account.Balance.Add(amount); err != nil {
+		return err
+	}
+	err = s.debit(revenue, amount)
+	if err != nil {
+		return err
+	}
+	err = s.credit(account, amount)
+	if err != nil {
+		return err
+	}
+	fee.Refunded += amount
+	fee.RefundedAt = s.clock().UTC()
+	return nil
+}
+
+func (s *Service) PaymentFee(paymentID string) (*types.Fee, error) {
+	for _, fee := range s.fees {
+		if fee.PaymentID == paymentID {
+			return fee, nil
+		}
+	}
+
+	return nil, ErrPaymentNotFound
+}
+
+func feeRows(fees []*types.Fee) [][]string {
+	rows := make([][]string, len(fees))
+	for i, fee := range fees {
+		rows[i] = []string{
+			fee.ID,
+			fee.PaymentID,
+			strconv.FormatInt(fee.AccountID, 10),
+			strconv.FormatInt(fee.RevenueAccountID, 10),
+			strconv.FormatInt(int64(fee.Amount), 10),
+			strconv.FormatInt(int64(fee.Refunded), 10),
+			formatTime(fee.Created),
+			formatTime(fee.RefundedAt),
+		}
+	}
+	return rows
+}
+
+func parseFees(rows [][]string) []*types.Fee {
+	fees := make([]*types.Fee, 0, len(rows))
+	for _, row := range rows {
+		accID, _ := strconv.ParseInt(dumpField(row, 2), 10, 64)
+		revenueID, _ := strconv.ParseInt(dumpField(row, 3), 10, 64)
+		amount, _ := strconv.ParseInt(dumpField(row, 4), 10, 64)
+		refunded, _ := strconv.ParseInt(dumpField(row, 5), 10, 64)
+		fees = append(fees, &types.Fee{
+			ID:               row[0],
+			PaymentID:        dumpField(row, 1),
+			AccountID:        accID,
+			RevenueAccountID: revenueID,
+			Amount:           types.Money(amount),
+			Refunded:         types.Money(refunded),
+			Created:          parseTime(dumpField(row, 6)),
+			RefundedAt:       parseTime(dumpField(row, 7)),
+		})
+	}
+	return fees
+}
@@ -0,0 +1,157 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestFeeRule_Fee(t *testing.T) {
+	tiered := FeeRule{
+		Tiers: []FeeTier{
+			{UpTo: 1_000, Flat: 10},
+			{UpTo: 10_000, Percent: 100},
+			{Percent: 50},
+		},
+		Max: 150,
+	}
+	tests := []struct {
+		rule   FeeRule
+		amount types.Money
+		want   types.Money
+	}{
+		{FeeRule{Flat: 5}, 1_000, 5},
+		{FeeRule{Percent: 150}, 10_000, 150},
+		{FeeRule{Percent: 100, Min: 20}, 1_000, 20},
+		{FeeRule{Flat: 10, Percent: 100, Max: 50}, 10_000, 50},
+		{tiered, 500, 10},
+		{tiered, 5_000, 50},
+		{tiered, 20_000, 100},
+		{tiered, 1_000_000, 150},
+	}
+	for _, tt := range tests {
+		if got, err := tt.rule.Fee(tt.amount); err != nil || got != tt.want {
+			t.Errorf("Fee(%d): got %d, want %d, error = %v, rule = %v", tt.amount, got, tt.want, err, tt.rule)
+		}
+	}
+	if _, err := (FeeRule{Percent: 100}).Fee(types.MaxMoney / 10); err != types.ErrMoneyOverflow {
+		t.Errorf("Fee(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+}
+
+func TestService_Pay_fee(t *testing.T) {
+	s := newTestService()
+	_, err := s.addAccountWithBalance("+992985570302", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	revenue, err := s.RegisterAccount("+992900000000")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.SetFeeSchedule(revenue.ID, []FeeRule{
+		{Category: "auto", Percent: 200},
+		{Flat: 1},
+	})
+	if err != nil {
+		t.Errorf("SetFeeSchedule(): error = %v", err)
+		return
+	}
+
+	_, err = s.Pay(1, 9_900, "auto")
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Pay(): must return ErrNotEnoughBalance for amount plus fee, returned = %v", err)
+	}
+	payment, err := s.Pay(1, 5_000, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	_, err = s.Pay(1, 1_000, "grocery")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	account, _ := s.FindAccountByID(1)
+	if account.Balance != 10_000-5_000-100-1_000-1 || revenue.Balance != 101 {
+		t.Errorf("Pay(): account = %v, revenue = %v", account, revenue)
+	}
+	fee, err := s.PaymentFee(payment.ID)
+	if err != nil || fee.Amount != 100 {
+		t.Errorf("PaymentFee(): fee = %v, error = %v", fee, err)
+	}
+
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Errorf("Reject(): error = %v", err)
+		return
+	}
+	err = s.Reject(payment.ID)
+	if err != ErrPaymentRejected {
+		t.Errorf("Reject(): must return ErrPaymentRejected, returned = %v", err)
+	}
+	if account.Balance != 10_000-1_000-1 || revenue.Balance != 1 || fee.Refunded != 100 {
+		t.Errorf("Reject(): account = %v, revenue = %v, fee = %v", account, revenue, fee)
+	}
+	if mismatches := s.VerifyBalances(); len(mismatches) != 0 {
+		t.Errorf("VerifyBalances(): got mismatches = %v", mismatches)
+	}
+}
+
+func TestService_Reject_feeRefundOverflow(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992985570302", types.MaxMoney)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	revenue, err := s.RegisterAccount("+992900000000")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.SetFeeSchedule(revenue.ID, []FeeRule{{Flat: 1_000_000}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, types.MaxMoney/4, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	balance := account.Balance
+
+	// the proportional fee refund multiplies the fee by the refunded amount
+	err = s.Reject(payment.ID)
+	if !errors.Is(err, types.ErrMoneyOverflow) {
+		t.Errorf("Reject(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+	if account.Balance != balance || revenue.Balance != 1_000_000 || payment.Status != types.PaymentStatusInProgress {
+		t.Errorf("Reject(): account = %v, revenue = %v, payment = %v", account, revenue, payment)
+	}
+}
+
+func TestService_SetFeeSchedule_invalid(t *testing.T) {
+	s := newTestService()
+	_, err := s.RegisterAccount("+992900000000")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.SetFeeSchedule(1, []FeeRule{{Min: 10, Max: 5}})
+	if err != ErrInvalidFeeRule {
+		t.Errorf("SetFeeSchedule(): must return ErrInvalidFeeRule, returned = %v", err)
+	}
+	err = s.SetFeeSchedule(1, []FeeRule{{Tiers: []FeeTier{{UpTo: 100}, {UpTo: 50}}}})
+	if err != ErrInvalidFeeRule {
+		t.Errorf("SetFeeSchedule(): must return ErrInvalidFeeRule for unordered tiers, returned = %v", err)
+	}
+	err = s.SetFeeSchedule(2, nil)
+	if err != ErrAccountNotFound {
+		t.Errorf("SetFeeSchedule(): must return ErrAccountNotFound, returned = %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package wallet
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+type HistoryQuery struct {
+	Cursor string
+	Limit  int
+	SortBy PaymentSortKey
+	Desc   bool
+}
+
+// HistoryEntry is a payment or a deposit, Kind tells which one is set.
+type HistoryEntry struct {
+	Kind    EntryKind
+	Payment *types.Payment
+	Deposit *types.Deposit
+}
+
+// HistoryPage holds the entries of one page in the requested order. Deposits
+// are ordered together with payments by their amount, time and ID and sort
+// before payments by category or status, which they don't have.
+type HistoryPage struct {
+	Entries    []HistoryEntry
+	NextCursor string
+}
+
+// historyItem orders an entry; deposits are ordered through a payment with
+// the same ID, account, amount and time.
+type historyItem struct {
+	key   types.Payment
+	entry HistoryEntry
+}
+
+func depositKey(deposit *types.Deposit) types.Payment {
+	return types.Payment{
+		ID:        deposit.ID,
+		AccountID: deposit.AccountID,
+		Amount:    deposit.Amount,
+		Created:   deposit.Created,
+	}
+}
+
+// cursor is the position of the last payment on a page. Pages continue strictly
+// after it, so payments added between requests never shift or repeat entries.
+type cursor struct {
+	SortBy    PaymentSortKey `json:"k"`
+	Desc      bool           `json:"d"`
+	Amount    types.Money    `json:"a,omitempty"`
+	Category  string         `json:"c,omitempty"`
+	Status    string         `json:"s,omitempty"`
+	AccountID int64          `json:"o,omitempty"`
+	Created   int64          `json:"t,omitempty"`
+	ID        string         `json:"i"`
+}
+
+func encodeCursor(query HistoryQuery, payment types.Payment) string {
+	data, _ := json.Marshal(cursor{
+		SortBy:    query.SortBy,
+		Desc:      query.Desc,
+		Amount:    payment.Amount,
+		Category:  string(payment.Category),
+		Status:    string(payment.Status),
+		AccountID: payment.AccountID,
+		Created:   unixNano(payment.Created),
+		ID:        payment.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(query HistoryQuery) (*types.Payment, error) {
+	data, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	err = json.Unmarshal(data, &c)
+	if err != nil || c.ID == "" || c.SortBy != query.SortBy || c.Desc != query.Desc {
+		return nil, ErrInvalidCursor
+	}
+	return &types.Payment{
+		ID:        c.ID,
+		Amount:    c.Amount,
+		Category:  types.PaymentCategory(c.Category),
+		Status:    types.PaymentStatus(c.Status),
+		AccountID: c.AccountID,
+		Created:   fromUnixNano(c.Created),
+	}, nil
+}
+
+// historyLess orders by the requested key, then by time and ID, so every
+// payment has a unique position a cursor can point to.
+func historyLess(key PaymentSortKey, desc bool) (func(a, b types.Payment) bool, error) {
+	less, err := paymentLess(key)
+	if err != nil {
+		return nil, err
+	}
+	ordered := func(a, b types.Payment) bool {
+		if less != nil {
+			if less(a, b) {
+				return true
+			}
+			if less(b, a) {
+				return false
+			}
+		}
+		if !a.Created.Equal(b.Created) {
+			return a.Created.Before(b.Created)
+		}
+		return a.ID < b.ID
+	}
+	if desc {
+		return func(a, b types.Payment) bool { return ordered(b, a) }, nil
+	}
+	return ordered, nil
+}
+
+func (s *Service) AccountHistoryPage(accountID int64, query HistoryQuery) (*HistoryPage, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	less, err := historyLess(query.SortBy, query.Desc)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	var after *types.Payment
+	if query.Cursor != "" {
+		after, err = decodeCursor(query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var items []historyItem
+	for _, payment := range s.payments {
+		if payment.AccountID == accountID {
+			copied := *payment
+			items = append(items, historyItem{key: copied, entry: HistoryEntry{Kind: EntryPayment, Payment: &copied}})
+		}
+	}
+	for _, deposit := range s.deposits {
+		if deposit.AccountID == accountID {
+			copied := *deposit
+			items = append(items, historyItem{key: depositKey(deposit), entry: HistoryEntry{Kind: EntryDeposit, Deposit: &copied}})
+		}
+	}
+	if after != nil {
+		rest := items[:0]
+		for _, item := range items {
+			if less(*after, item.key) {
+				rest = append(rest, item)
+			}
+		}
+		items = rest
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return less(items[i].key, items[j].key)
+	})
+
+	page := &HistoryPage{}
+	if len(items) > limit {
+		items = items[:limit]
+		page.NextCursor = encodeCursor(query, items[limit-1].key)
+	}
+	page.Entries = make([]HistoryEntry, len(items))
+	for i, item := range items {
+		page.Entries[i] = item.entry
+	}
+	return page, nil
+}
@@ -0,0 +1,132 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func newServiceWithClock() (*testService, *time.Time) {
+	s := newTestService()
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	return s, &now
+}
+
+func TestService_AccountHistoryPage(t *testing.T) {
+	s, _ := newServiceWithClock()
+	_, err := s.addAccountWithBalance("+992985570302", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 25; i++ {
+		_, err = s.Pay(1, types.Money(i%5)+1, "auto")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	query := HistoryQuery{Limit: 10, SortBy: SortByAmount, Desc: true}
+	seen := make(map[string]bool)
+	var last *types.Payment
+	pages := 0
+	for {
+		page, err := s.AccountHistoryPage(1, query)
+		if err != nil {
+			t.Errorf("AccountHistoryPage(): error = %v", err)
+			return
+		}
+		pages++
+		for _, entry := range page.Entries {
+			var amount types.Money
+			if entry.Kind == EntryPayment {
+				amount = entry.Payment.Amount
+			} else {
+				amount = entry.Deposit.Amount
+			}
+			if last != nil && last.Amount < amount {
+				t.Errorf("AccountHistoryPage(): not sorted by amount desc, %v before %v", last, entry)
+				return
+			}
+			last = &types.Payment{Amount: amount}
+			if entry.Kind != EntryPayment {
+				continue
+			}
+			payment := *entry.Payment
+			if seen[payment.ID] {
+				t.Errorf("AccountHistoryPage(): payment returned twice = %v", payment)
+				return
+			}
+			seen[payment.ID] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+		if pages == 1 {
+			// arrives between page requests and sorts after the cursor
+			_, err = s.Pay(1, 1, "auto")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+	if pages != 3 || len(seen) != 26 {
+		t.Errorf("AccountHistoryPage(): got %d pages with %d payments, want 3 with 26", pages, len(seen))
+	}
+}
+
+func TestService_AccountHistoryPage_invalidCursor(t *testing.T) {
+	s := newTestService()
+	_, _, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.AccountHistoryPage(1, HistoryQuery{Cursor: "garbage"})
+	if err != ErrInvalidCursor {
+		t.Errorf("AccountHistoryPage(): must return ErrInvalidCursor, returned = %v", err)
+	}
+}
+
+func TestService_ExportImport_keepsPaymentTime(t *testing.T) {
+	s, _ := newServiceWithClock()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.FavoritePayment(payments[0].ID, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Errorf("Export(): error = %v", err)
+		return
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+	got, err := imported.FindPaymentByID(payments[0].ID)
+	if err != nil {
+		t.Errorf("Import(): can't find payment, error = %v", err)
+		return
+	}
+	if !got.Created.Equal(payments[0].Created) || got.AccountID != payments[0].AccountID {
+		t.Errorf("Import(): got payment %v, want %v", got, payments[0])
+	}
+}
@@ -0,0 +1,127 @@
+package wallet
+
+import (
+	"sort"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+type EntryKind string
+
+const (
+	EntryDeposit         EntryKind = "deposit"
+	EntryPayment         EntryKind = "payment"
+	EntryRefund          EntryKind = "refund"
+	EntryDepositReversal EntryKind = "deposit_reversal"
+	EntryFee             EntryKind = "fee"
+	EntryFeeRefund       EntryKind = "fee_refund"
+	EntryFeeRevenue      EntryKind = "fee_revenue"
+	EntryFeeRevenueBack  EntryKind = "fee_revenue_refund"
+)
+
+// LedgerEntry is one movement of an account balance. Amount is signed:
+// credits are positive and debits negative.
+type LedgerEntry struct {
+	Time      time.Time             `json:"time"`
+	Kind      EntryKind             `json:"kind"`
+	Reference string                `json:"reference"`
+	Category  types.PaymentCategory `json:"category,omitempty"`
+	Source    string                `json:"source,omitempty"`
+	Amount    types.Money           `json:"amount"`
+	Balance   types.Money           `json:"balance"`
+}
+
+// ledger rebuilds every balance movement of the account in time order with a
+// running balance starting from zero. Records imported without timestamps
+// sort first.
+func (s *Service) ledger(accountID int64) []LedgerEntry {
+	var entries []LedgerEntry
+	for _, deposit := range s.deposits {
+		if deposit.AccountID != accountID {
+			continue
+		}
+		entries = append(entries, LedgerEntry{
+			Time:      deposit.Created,
+			Kind:      EntryDeposit,
+			Reference: deposit.ID,
+			Source:    deposit.Source,
+			Amount:    deposit.Amount,
+		})
+		if !deposit.Reversed.IsZero() {
+			entries = append(entries, LedgerEntry{
+				Time:      deposit.Reversed,
+				Kind:      EntryDepositReversal,
+				Reference: deposit.ID,
+				Source:    deposit.Source,
+				Amount:    -deposit.Amount,
+			})
+		}
+	}
+	for _, payment := range s.payments {
+		if payment.AccountID != accountID {
+			continue
+		}
+		entries = append(entries, LedgerEntry{
+			Time:      payment.Created,
+			Kind:      EntryPayment,
+			Reference: payment.ID,
+			Category:  payment.Category,
+			Amount:    -payment.Amount,
+		})
+		if payment.Status == types.PaymentStatusFail {
+			entries = append(entries, LedgerEntry{
+				Time:      payment.Rejected,
+				Kind:      EntryRefund,
+				Reference: payment.ID,
+				Category:  payment.Category,
+				Amount:    payment.Amount,
+			})
+		}
+	}
+
+	for _, fee := range s.fees {
+		if fee.AccountID == accountID {
+			entries = append(entries, LedgerEntry{
+				Time:      fee.Created,
+				Kind:      EntryFee,
+				Reference: fee.PaymentID,
+				Amount:    -fee.Amount,
+			})
+			if fee.Refunded > 0 {
+				entries = append(entries, LedgerEntry{
+					Time:      fee.RefundedAt,
+					Kind:      EntryFeeRefund,
+					Reference: fee.PaymentID,
+					Amount:    fee.Refunded,
+				})
+			}
+		}
+		if fee.RevenueAccountID == accountID {
+			entries = append(entries, LedgerEntry{
+				Time:      fee.Created,
+				Kind:      EntryFeeRevenue,
+				Reference: fee.PaymentID,
+				Amount:    fee.Amount,
+			})
+			if fee.Refunded > 0 {
+				entries = append(entries, LedgerEntry{
+					Time:      fee.RefundedAt,
+					Kind:      EntryFeeRevenueBack,
+					Reference: fee.PaymentID,
+					Amount:    -fee.Refunded,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	balance := types.Money(0)
+	for i := range entries {
+		balance += entries[i].Amount
+		entries[i].Balance = balance
+	}
+	return entries
+}
@@ -0,0 +1,205 @@
+package wallet
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OFXCurrency is the currency written to OFX statements.
+const OFXCurrency = "TJS"
+
+// HistoryStatement covers the whole history of the account up to now, for
+// exporting it to personal finance software.
+func (s *Service) HistoryStatement(accountID int64) (*Statement, error) {
+	return s.Statement(accountID, time.Time{}, s.clock().UTC().Add(time.Nanosecond))
+}
+
+// transactionID derives a stable id from the entry's deposit or payment id.
+// A payment and its refund or fee share the reference, so the kind is added
+// for everything but the original movement.
+func transactionID(entry LedgerEntry) string {
+	id := strings.ReplaceAll(entry.Reference, "-", "")
+	switch entry.Kind {
+	case EntryDeposit, EntryPayment:
+		return id
+	}
+	return id + "-" + string(entry.Kind)
+}
+
+func ofxType(entry LedgerEntry) string {
+	switch entry.Kind {
+	case EntryDeposit:
+		if strings.HasPrefix(entry.Source, "p2p:") {
+			return "XFER"
+		}
+		return "DEP"
+	case EntryPayment:
+		switch entry.Category {
+		case TransferCategory:
+			return "XFER"
+		case OverdraftInterestCategory:
+			return "INT"
+		}
+		return "PAYMENT"
+	case EntryFee:
+		return "FEE"
+	}
+	if entry.Amount < 0 {
+		return "DEBIT"
+	}
+	return "CREDIT"
+}
+
+// payee names the other side of the entry: the category of payments and
+// the source of deposits.
+func payee(entry LedgerEntry) string {
+	if entry.Category != "" {
+		return string(entry.Category)
+	}
+	if entry.Source != "" {
+		return entry.Source
+	}
+	return string(entry.Kind)
+}
+
+func ofxTime(t time.Time) string {
+	return t.UTC().Format("20060102150405.000") + "[0:GMT]"
+}
+
+type ofxStatus struct {
+	Code     int    `xml:"CODE"`
+	Severity string `xml:"SEVERITY"`
+}
+
+type ofxTransaction struct {
+	Type   string `xml:"TRNTYPE"`
+	Posted string `xml:"DTPOSTED"`
+	Amount string `xml:"TRNAMT"`
+	ID     string `xml:"FITID"`
+	Name   string `xml:"NAME"`
+	Memo   string `xml:"MEMO,omitempty"`
+}
+
+type ofxDocument struct {
+	XMLName xml.Name `xml:"OFX"`
+	SignOn  struct {
+		Status   ofxStatus `xml:"STATUS"`
+		Server   string    `xml:"DTSERVER"`
+		Language string    `xml:"LANGUAGE"`
+	} `xml:"SIGNONMSGSRSV1>SONRS"`
+	Statement struct {
+		ID        string           `xml:"TRNUID"`
+		Status    ofxStatus        `xml:"STATUS"`
+		Currency  string           `xml:"STMTRS>CURDEF"`
+		BankID    string           `xml:"STMTRS>BANKACCTFROM>BANKID"`
+		AccountID string           `xml:"STMTRS>BANKACCTFROM>ACCTID"`
+		Type      string           `xml:"STMTRS>BANKACCTFROM>ACCTTYPE"`
+		Start     string           `xml:"STMTRS>BANKTRANLIST>DTSTART"`
+		End       string           `xml:"STMTRS>BANKTRANLIST>DTEND"`
+		Entries   []ofxTransaction `xml:"STMTRS>BANKTRANLIST>STMTTRN"`
+		Balance   string           `xml:"STMTRS>LEDGERBAL>BALAMT"`
+		AsOf      string           `xml:"STMTRS>LEDGERBAL>DTASOF"`
+	} `xml:"BANKMSGSRSV1>STMTTRNRS"`
+}
+
+// WriteOFX writes the statement as an OFX 2.1 bank statement. Amounts are
+// in major units and FITIDs stay the same on every export, so importing an
+// overlapping period again doesn't duplicate transactions.
+func (st *Statement) WriteOFX(w io.Writer) error {
+	var document ofxDocument
+	document.SignOn.Status.Severity = "INFO"
+	document.SignOn.Server = ofxTime(st.To)
+	document.SignOn.Language = "ENG"
+
+	statement := &document.Statement
+	statement.ID = "0"
+	statement.Status.Severity = "INFO"
+	statement.Currency = OFXCurrency
+	statement.BankID = "WALLET"
+	statement.AccountID = strconv.FormatInt(st.AccountID, 10)
+	statement.Type = "CHECKING"
+	entries := datedEntries(st.Entries)
+	start := st.From
+	if start.IsZero() && len(entries) > 0 {
+		start = entries[0].Time
+	}
+	statement.Start = ofxTime(start)
+	statement.End = ofxTime(st.To)
+	for _, entry := range entries {
+		statement.Entries = append(statement.Entries, ofxTransaction{
+			Type:   ofxType(entry),
+			Posted: ofxTime(entry.Time),
+			Amount: entry.Amount.Decimal(),
+			ID:     transactionID(entry),
+			Name:   truncate(payee(entry), 32),
+			Memo:   string(entry.Kind),
+		})
+	}
+	statement.Balance = st.Closing.Decimal()
+	statement.AsOf = ofxTime(st.To)
+
+	_, err := io.WriteString(w, xml.Header+`<?OFX OFXHEADER="200" VERSION="211" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>`+"\n")
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(document)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// datedEntries leaves out entries of records imported without timestamps,
+// which finance software would otherwise post in year 1. The closing balance
+// still includes them.
+func datedEntries(entries []LedgerEntry) []LedgerEntry {
+	var dated []LedgerEntry
+	for _, entry := range entries {
+		if !entry.Time.IsZero() {
+			dated = append(dated, entry)
+		}
+	}
+	return dated
+}
+
+func truncate(value string, size int) string {
+	runes := []rune(value)
+	if len(runes) <= size {
+		return value
+	}
+	return string(runes[:size])
+}
+
+// WriteQIF writes the statement as a QIF bank register. QIF has no
+// transaction id field, so the stable id goes to the check number.
+func (st *Statement) WriteQIF(w io.Writer) error {
+	_, err := io.WriteString(w, "!Type:Bank\n")
+	if err != nil {
+		return err
+	}
+	for _, entry := range datedEntries(st.Entries) {
+		_, err = fmt.Fprintf(w, "D%s\nT%s\nN%s\nP%s\nM%s\n^\n",
+			entry.Time.UTC().Format("01/02/2006"),
+			entry.Amount.Decimal(),
+			transactionID(entry),
+			qifText(payee(entry)),
+			qifText(string(entry.Kind)+" "+entry.Reference),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// qifText keeps a value on one line, QIF fields can't span lines.
+func qifText(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
@@ -0,0 +1,125 @@
+package wallet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatement_WriteOFX(t *testing.T) {
+	s := newTestService()
+	now := time.Date(2022, 9, 1, 8, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	_, err := s.addAccountWithBalance("+992985570302", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now = now.Add(time.Hour)
+	payment, err := s.Pay(1, 1_050, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now = now.Add(time.Hour)
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	statement, err := s.HistoryStatement(1)
+	if err != nil {
+		t.Errorf("HistoryStatement(): error = %v", err)
+		return
+	}
+	if len(statement.Entries) != 3 {
+		t.Errorf("HistoryStatement(): entries = %v", statement.Entries)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = statement.WriteOFX(&buf)
+	if err != nil {
+		t.Errorf("WriteOFX(): error = %v", err)
+		return
+	}
+	id := strings.ReplaceAll(payment.ID, "-", "")
+	for _, want := range []string{
+		`<?OFX OFXHEADER="200" VERSION="211"`,
+		`<DTSTART>20220901080000.000[0:GMT]</DTSTART>`,
+		`<TRNTYPE>DEP</TRNTYPE>`,
+		`<TRNAMT>100.00</TRNAMT>`,
+		`<TRNTYPE>PAYMENT</TRNTYPE>`,
+		`<DTPOSTED>20220901090000.000[0:GMT]</DTPOSTED>`,
+		`<TRNAMT>-10.50</TRNAMT>`,
+		`<FITID>` + id + `</FITID>`,
+		`<FITID>` + id + `-refund</FITID>`,
+		`<BALAMT>100.00</BALAMT>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("WriteOFX(): output has no %s:\n%s", want, buf.String())
+		}
+	}
+
+	first := buf.String()
+	buf.Reset()
+	err = statement.WriteOFX(&buf)
+	if err != nil || buf.String() != first {
+		t.Errorf("WriteOFX(): output must be stable, error = %v", err)
+	}
+
+	buf.Reset()
+	err = statement.WriteQIF(&buf)
+	if err != nil {
+		t.Errorf("WriteQIF(): error = %v", err)
+		return
+	}
+	want := "D09/01/2022\nT-10.50\nN" + id + "\nPauto\nMpayment " + payment.ID + "\n^\n"
+	if !strings.HasPrefix(buf.String(), "!Type:Bank\n") || !strings.Contains(buf.String(), want) || strings.Count(buf.String(), "^\n") != 3 {
+		t.Errorf("WriteQIF(): got\n%s", buf.String())
+	}
+}
+
+func TestStatement_WriteOFX_undated(t *testing.T) {
+	s := newTestService()
+	now := time.Date(2022, 9, 1, 8, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	_, err := s.addAccountWithBalance("+992985570302", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// imported without a timestamp
+	s.deposits[0].Created = time.Time{}
+	now = now.Add(time.Hour)
+	_, err = s.Pay(1, 1_050, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	statement, err := s.HistoryStatement(1)
+	if err != nil {
+		t.Errorf("HistoryStatement(): error = %v", err)
+		return
+	}
+	var buf bytes.Buffer
+	err = statement.WriteOFX(&buf)
+	if err != nil {
+		t.Errorf("WriteOFX(): error = %v", err)
+		return
+	}
+	if strings.Contains(buf.String(), ">0001") || strings.Count(buf.String(), "<STMTTRN>") != 1 ||
+		!strings.Contains(buf.String(), `<DTSTART>20220901090000.000[0:GMT]</DTSTART>`) ||
+		!strings.Contains(buf.String(), `<BALAMT>89.50</BALAMT>`) {
+		t.Errorf("WriteOFX(): got\n%s", buf.String())
+	}
+
+	buf.Reset()
+	err = statement.WriteQIF(&buf)
+	if err != nil || strings.Contains(buf.String(), "/0001\n") || strings.Count(buf.String(), "^\n") != 1 {
+		t.Errorf("WriteQIF(): got\n%s, error = %v", buf.String(), err)
+	}
+}
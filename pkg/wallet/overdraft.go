@@ -0,0 +1,188 @@
+package wallet
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidCreditLimit = errors.New("invalid credit limit")
+var ErrChargeNotRejectable = errors.New("charges can't be rejected")
+
+const OverdraftInterestCategory types.PaymentCategory = "overdraft_interest"
+
+const basisPoints = 10_000
+
+func available(account *types.Account) types.Money {
+	sum, err := account.Balance.Add(account.CreditLimit)
+	if err != nil {
+		// only a positive balance plus the limit can overflow
+		return types.MaxMoney
+	}
+	return sum
+}
+
+// chargeCategory reports whether payments of the category are charges the
+// wallet takes from the account rather than the customer's own spending.
+func chargeCategory(category types.PaymentCategory) bool {
+	return category == OverdraftInterestCategory || category == CashbackClawbackCategory
+}
+
+// interestScale is the number of UnchargedInterest units in a diram.
+const interestScale = 1_000_000
+
+// accrue adds the interest on the overdrawn balance since it was last
+// accrued to UnchargedInterest. It runs before every balance change, so each
+// balance is charged only for the time it was held. Only the charge is
+// rounded to whole dirams.
+func (s *Service) accrue(account *types.Account, at time.Time) {
+	if account.Balance >= 0 || account.OverdrawnSince.IsZero() {
+		return
+	}
+	from := account.OverdrawnSince
+	if account.InterestAccrued.After(from) {
+		from = account.InterestAccrued
+	}
+	if !at.After(from) {
+		return
+	}
+	if account.OverdraftRate > 0 {
+		years := at.Sub(from).Hours() / (365 * 24)
+		interest := math.Round(float64(-account.Balance) * float64(account.OverdraftRate) / basisPoints * years * interestScale)
+		if sum, err := types.Money(account.UnchargedInterest).Add(types.Money(interest)); err == nil {
+			account.UnchargedInterest = int64(sum)
+		}
+	}
+	account.InterestAccrued = at.UTC()
+}
+
+// debit and credit leave the account unchanged when the balance would
+// overflow.
+func (s *Service) debit(account *types.Account, amount types.Money) error {
+	balance, err := account.Balance.Sub(amount)
+	if err != nil {
+		return err
+	}
+	s.accrue(account, s.clock())
+	if account.Balance >= 0 && balance < 0 {
+		account.OverdrawnSince = s.clock().UTC()
+	}
+	account.Balance = balance
+	return nil
+}
+
+func (s *Service) credit(account *types.Account, amount types.Money) error {
+	balance, err := account.Balance.Add(amount)
+	if err != nil {
+		return err
+	}
+	s.accrue(account, s.clock())
+	account.Balance = balance
+	if account.Balance >= 0 {
+		account.OverdrawnSince = time.Time{}
+	}
+	return nil
+}
+
+// charge takes a fee from the account regardless of its limit and records it
+// as a completed payment so it appears in history and statements.
+func (s *Service) charge(account *types.Account, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	err := s.debit(account, amount)
+	if err != nil {
+		return nil, err
+	}
+	payment := &types.Payment{
+		ID:        uuid.New().String(),
+		AccountID: account.ID,
+		Amount:    amount,
+		Category:  category,
+		Status:    types.PaymentStatusOk,
+		Created:   s.clock().UTC(),
+	}
+	s.payments = append(s.payments, payment)
+	return payment, nil
+}
+
+// SetCreditLimit lets the account go down to -limit. rate is the yearly
+// overdraft interest in basis points (1250 is 12.5%).
+func (s *Service) SetCreditLimit(accountID int64, limit types.Money, rate int64) (err error) {
+	defer func() {
+		s.record("SetCreditLimit", auditArgs("account", accountID, "limit", limit, "rate", rate), "", err)
+	}()
+
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+	if limit < 0 || rate < 0 {
+		return ErrInvalidCreditLimit
+	}
+	available, err := account.Balance.Add(limit)
+	if err != nil {
+		return err
+	}
+	if available < 0 {
+		return ErrInvalidCreditLimit
+	}
+
+	// the old rate applies up to now
+	s.accrue(account, s.clock())
+	account.CreditLimit = limit
+	account.OverdraftRate = rate
+	return nil
+}
+
+// AccrueOverdraftInterest charges the overdraft interest of every account:
+// the interest accrued on each earlier balance while it was held, plus the
+// current overdrawn balance up to asOf. It is meant to run periodically,
+// e.g. once a day.
+func (s *Service) AccrueOverdraftInterest(asOf time.Time) (charges []types.Payment, err error) {
+	defer func() {
+		s.record("AccrueOverdraftInterest", auditArgs("asOf", asOf.UTC().Format(time.RFC3339), "charges", len(charges)), "", err)
+	}()
+
+	for _, account := range s.accounts {
+		s.accrue(account, asOf)
+		interest := types.Money(math.Round(float64(account.UnchargedInterest) / interestScale))
+		if interest <= 0 {
+			continue
+		}
+		payment, err := s.charge(account, interest, OverdraftInterestCategory)
+		if err != nil {
+			return charges, err
+		}
+		// the rounding difference stays for the next charge
+		account.UnchargedInterest -= int64(interest) * interestScale
+		charges = append(charges, *payment)
+	}
+	return charges, nil
+}
+
+type Overdraft struct {
+	AccountID   int64
+	Balance     types.Money
+	CreditLimit types.Money
+	Since       time.Time
+}
+
+func (s *Service) OverdraftAccounts() []Overdraft {
+	var result []Overdraft
+	for _, account := range s.accounts {
+		if account.Balance < 0 {
+			result = append(result, Overdraft{
+				AccountID:   account.ID,
+				Balance:     account.Balance,
+				CreditLimit: account.CreditLimit,
+				Since:       account.OverdrawnSince,
+			})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Balance < result[j].Balance
+	})
+	return result
+}
@@ -0,0 +1,223 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestService_Pay_overdraft(t *testing.T) {
+	s := newTestService()
+	_, err := s.addAccountWithBalance("+992985570302", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(1, 1_500, "auto")
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Pay(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+
+	err = s.SetCreditLimit(1, 1_000, 3650)
+	if err != nil {
+		t.Errorf("SetCreditLimit(): error = %v", err)
+		return
+	}
+	_, err = s.Pay(1, 1_500, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	_, err = s.Pay(1, 600, "auto")
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Pay(): must return ErrNotEnoughBalance over the limit, returned = %v", err)
+	}
+	err = s.SetCreditLimit(1, 100, 0)
+	if err != ErrInvalidCreditLimit {
+		t.Errorf("SetCreditLimit(): must return ErrInvalidCreditLimit, returned = %v", err)
+	}
+
+	overdrafts := s.OverdraftAccounts()
+	if len(overdrafts) != 1 || overdrafts[0].Balance != -500 {
+		t.Errorf("OverdraftAccounts(): got %v", overdrafts)
+	}
+}
+
+func TestService_SetCreditLimit_overflow(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992985570302", types.MaxMoney)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.SetCreditLimit(account.ID, 1, 0)
+	if !errors.Is(err, types.ErrMoneyOverflow) {
+		t.Errorf("SetCreditLimit(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+	if account.CreditLimit != 0 {
+		t.Errorf("SetCreditLimit(): credit limit = %d", account.CreditLimit)
+	}
+}
+
+func TestService_Reject_overflow(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992985570302", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(account.ID, types.MaxMoney-account.Balance)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.Reject(payment.ID)
+	if !errors.Is(err, types.ErrMoneyOverflow) {
+		t.Errorf("Reject(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+	if account.Balance != types.MaxMoney || payment.Status != types.PaymentStatusInProgress {
+		t.Errorf("Reject(): balance = %d, payment = %v", account.Balance, payment)
+	}
+}
+
+func TestService_AccrueOverdraftInterest(t *testing.T) {
+	s := newTestService()
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	_, err := s.RegisterAccount("+992985570302")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.SetCreditLimit(1, 100_000, 3650)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(1, 100_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 36.5% a year is 0.1% a day
+	charges, err := s.AccrueOverdraftInterest(now.AddDate(0, 0, 10))
+	if err != nil {
+		t.Errorf("AccrueOverdraftInterest(): error = %v", err)
+		return
+	}
+	if len(charges) != 1 || charges[0].Amount != 1_000 || charges[0].Category != OverdraftInterestCategory {
+		t.Errorf("AccrueOverdraftInterest(): got charges %v", charges)
+		return
+	}
+	charges, err = s.AccrueOverdraftInterest(now.AddDate(0, 0, 10))
+	if err != nil || len(charges) != 0 {
+		t.Errorf("AccrueOverdraftInterest(): charged twice, charges = %v, error = %v", charges, err)
+	}
+
+	err = s.Deposit(1, 200_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if overdrafts := s.OverdraftAccounts(); len(overdrafts) != 0 {
+		t.Errorf("OverdraftAccounts(): got %v", overdrafts)
+	}
+	if mismatches := s.VerifyBalances(); len(mismatches) != 0 {
+		t.Errorf("VerifyBalances(): got mismatches = %v", mismatches)
+	}
+}
+
+func TestService_AccrueOverdraftInterest_balanceChanges(t *testing.T) {
+	s := newTestService()
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	_, err := s.RegisterAccount("+992985570302")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.SetCreditLimit(1, 100_000, 3650)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(1, 10_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 10 days on 10_000, then 10 days on 100_000
+	now = now.AddDate(0, 0, 10)
+	_, err = s.Pay(1, 90_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now = now.AddDate(0, 0, 10)
+	charges, err := s.AccrueOverdraftInterest(now)
+	if err != nil || len(charges) != 1 || charges[0].Amount != 1_100 {
+		t.Errorf("AccrueOverdraftInterest(): charges = %v, error = %v", charges, err)
+		return
+	}
+
+	if err := s.Reject(charges[0].ID); err != ErrChargeNotRejectable {
+		t.Errorf("Reject(): must return ErrChargeNotRejectable, returned = %v", err)
+	}
+	report, err := s.SpendingReport(context.Background(), ReportQuery{AccountID: 1}, 1)
+	if err != nil || report.Total != 100_000 {
+		t.Errorf("SpendingReport(): report = %v, error = %v, charges must not count", report, err)
+	}
+}
+
+func TestService_AccrueOverdraftInterest_daily(t *testing.T) {
+	s := newTestService()
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	for i, amount := range []types.Money{1_000, 10_000} {
+		_, err := s.RegisterAccount(types.Phone(fmt.Sprintf("+99290000000%d", i)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = s.SetCreditLimit(int64(i+1), 20_000, 1250)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, err = s.Pay(int64(i+1), amount, "auto")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	totals := make(map[int64]types.Money)
+	for day := 0; day < 365; day++ {
+		now = now.AddDate(0, 0, 1)
+		charges, err := s.AccrueOverdraftInterest(now)
+		if err != nil {
+			t.Errorf("AccrueOverdraftInterest(): error = %v", err)
+			return
+		}
+		for _, charge := range charges {
+			totals[charge.AccountID] += charge.Amount
+		}
+	}
+	// 12.5% a year, charged daily so it compounds to at most 13.3%
+	if totals[1] < 125 || totals[1] > 133 || totals[2] < 1_250 || totals[2] > 1_332 {
+		t.Errorf("AccrueOverdraftInterest(): yearly totals = %v", totals)
+	}
+}
@@ -0,0 +1,249 @@
+package wallet
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+// checkEvery is how many payments a worker scans between cancellation checks.
+const checkEvery = 1024
+
+type chunk struct {
+	index int
+	from  int
+	to    int
+}
+
+func chunks(length int, size int) []chunk {
+	if size <= 0 {
+		size = 1
+	}
+	var result []chunk
+	for from := 0; from < length; from += size {
+		to := from + size
+		if to > length {
+			to = length
+		}
+		result = append(result, chunk{index: len(result), from: from, to: to})
+	}
+	return result
+}
+
+func chunkSize(length int, workers int) int {
+	// a few chunks per worker so a slow chunk doesn't leave the others idle
+	parts := workers * 4
+	size := (length + parts - 1) / parts
+	if size < checkEvery {
+		size = checkEvery
+	}
+	return size
+}
+
+func normalizeWorkers(workers int) int {
+	if workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return workers
+}
+
+// scanPayments runs fn over the payments in chunks on at most workers goroutines.
+// fn receives the chunk index so callers can keep results in storage order.
+func scanPayments(ctx context.Context, payments []*types.Payment, workers int, parts []chunk, fn func(index int, part []*types.Payment) error) error {
+	workers = normalizeWorkers(workers)
+	if workers > len(parts) {
+		workers = len(parts)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan chunk)
+	errs := make(chan error, workers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if err := ctx.Err(); err != nil {
+					return
+				}
+				if err := fn(job.index, payments[job.from:job.to]); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, part := range parts {
+		select {
+		case jobs <- part:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	if err, ok := <-errs; ok {
+		return err
+	}
+	return ctx.Err()
+}
+
+// scanChunk stops at the first error of fn.
+func scanChunk(ctx context.Context, part []*types.Payment, fn func(payment *types.Payment) error) error {
+	for i, payment := range part {
+		if i%checkEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if err := fn(payment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sumChunk adds up the amounts of part, returning types.ErrMoneyOverflow if
+// they don't fit Money.
+func sumChunk(ctx context.Context, part []*types.Payment) (types.Money, error) {
+	sum := types.Money(0)
+	err := scanChunk(ctx, part, func(payment *types.Payment) (err error) {
+		sum, err = sum.Add(payment.Amount)
+		return err
+	})
+	return sum, err
+}
+
+func (s *Service) SumPaymentsContext(ctx context.Context, workers int) (types.Money, error) {
+	workers = normalizeWorkers(workers)
+	return sumPayments(ctx, s.payments, workers, chunks(len(s.payments), chunkSize(len(s.payments), workers)))
+}
+
+// sumPayments sums the parts on at most workers goroutines.
+func sumPayments(ctx context.Context, payments []*types.Payment, workers int, parts []chunk) (types.Money, error) {
+	sums := make([]types.Money, len(parts))
+	err := scanPayments(ctx, payments, workers, parts, func(index int, part []*types.Payment) (err error) {
+		sums[index], err = sumChunk(ctx, part)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sum := types.Money(0)
+	for _, val := range sums {
+		sum, err = sum.Add(val)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return sum, nil
+}
+
+func (s *Service) FilterPaymentsContext(ctx context.Context, accountID int64, workers int) ([]types.Payment, error) {
+	return s.FilterPaymentByFnContext(ctx, func(payment types.Payment) bool {
+		return payment.AccountID == accountID
+	}, workers)
+}
+
+func (s *Service) FilterPaymentByFnContext(ctx context.Context, filter func(payment types.Payment) bool, workers int) ([]types.Payment, error) {
+	payments := s.payments
+	workers = normalizeWorkers(workers)
+	parts := chunks(len(payments), chunkSize(len(payments), workers))
+	results := make([][]types.Payment, len(parts))
+	err := scanPayments(ctx, payments, workers, parts, func(index int, part []*types.Payment) error {
+		return scanChunk(ctx, part, func(payment *types.Payment) error {
+			if filter(*payment) {
+				results[index] = append(results[index], *payment)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var answer []types.Payment
+	for _, pays := range results {
+		answer = append(answer, pays...)
+	}
+	return answer, nil
+}
+
+// SumPaymentsWithProgressContext sums payments in parts of partSize. The channel
+// is buffered for every message, so it never blocks a slow reader and is closed
+// exactly once after the final Done message.
+func (s *Service) SumPaymentsWithProgressContext(ctx context.Context, partSize int) <-chan Progress {
+	payments := s.payments
+	parts := chunks(len(payments), partSize)
+	ch := make(chan Progress, len(parts)+1)
+
+	go func() {
+		defer close(ch)
+
+		results := make(chan Progress, len(parts))
+		scanErr := make(chan error, 1)
+		go func() {
+			defer close(results)
+			scanErr <- scanPayments(ctx, payments, 0, parts, func(index int, part []*types.Payment) error {
+				sum, err := sumChunk(ctx, part)
+				if err != nil {
+					return err
+				}
+				results <- Progress{Part: index, Result: sum}
+				return nil
+			})
+		}()
+
+		total := types.Money(0)
+		var totalErr error
+		completed := 0
+		percent := func() float64 {
+			if len(parts) == 0 {
+				return 100
+			}
+			return float64(completed) * 100 / float64(len(parts))
+		}
+		for progress := range results {
+			completed++
+			if totalErr == nil {
+				sum, err := total.Add(progress.Result)
+				if err != nil {
+					totalErr = err
+				} else {
+					total = sum
+				}
+			}
+			progress.Parts = len(parts)
+			progress.Completed = completed
+			progress.Percent = percent()
+			progress.Total = total
+			ch <- progress
+		}
+
+		err := <-scanErr
+		if err == nil {
+			err = totalErr
+		}
+		ch <- Progress{
+			Parts:     len(parts),
+			Completed: completed,
+			Percent:   percent(),
+			Total:     total,
+			Done:      true,
+			Err:       err,
+		}
+	}()
+
+	return ch
+}
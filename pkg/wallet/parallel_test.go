@@ -0,0 +1,238 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func newServiceWithPayments(tb testing.TB, count int) *testService {
+	s := newTestService()
+	_, err := s.addAccountWithBalance("+992985570302", types.Money(count)*10)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	_, err = s.addAccountWithBalance("+992981111111", types.Money(count)*10)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		_, err = s.Pay(int64(i%2)+1, types.Money(i%10)+1, "grocery")
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestService_SumPaymentsContext(t *testing.T) {
+	s := newServiceWithPayments(t, 10_000)
+	got, err := s.SumPaymentsContext(context.Background(), 4)
+	if err != nil {
+		t.Errorf("SumPaymentsContext(): error = %v", err)
+		return
+	}
+	if want, err := s.SumPayments(100); err != nil || got != want {
+		t.Errorf("SumPaymentsContext(): got %v, want %v, error = %v", got, want, err)
+	}
+}
+
+func TestService_FilterPaymentsContext(t *testing.T) {
+	s := newServiceWithPayments(t, 10_000)
+	got, err := s.FilterPaymentsContext(context.Background(), 2, 3)
+	if err != nil {
+		t.Errorf("FilterPaymentsContext(): error = %v", err)
+		return
+	}
+	if len(got) != 5_000 {
+		t.Errorf("FilterPaymentsContext(): got %d payments, want %d", len(got), 5_000)
+		return
+	}
+	for i, payment := range got {
+		if payment.AccountID != 2 {
+			t.Errorf("FilterPaymentsContext(): wrong payment returned = %v", payment)
+			return
+		}
+		if i > 0 && payment.ID == got[i-1].ID {
+			t.Errorf("FilterPaymentsContext(): duplicate payment returned = %v", payment)
+			return
+		}
+	}
+}
+
+func TestService_FilterPaymentByFnContext_cancelled(t *testing.T) {
+	s := newServiceWithPayments(t, 10_000)
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	_, err := s.FilterPaymentByFnContext(ctx, func(payment types.Payment) bool {
+		calls++
+		if calls == 10 {
+			cancel()
+		}
+		return true
+	}, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("FilterPaymentByFnContext(): must return context.Canceled, returned = %v", err)
+		return
+	}
+	if calls >= 10_000 {
+		t.Errorf("FilterPaymentByFnContext(): scanned all %d payments after cancel", calls)
+	}
+}
+
+func BenchmarkService_SumPayments_large(b *testing.B) {
+	s := newServiceWithPayments(b, 200_000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.SumPayments(200_000 / 8)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkService_SumPaymentsContext(b *testing.B) {
+	s := newServiceWithPayments(b, 200_000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.SumPaymentsContext(context.Background(), 8)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkService_FilterPayments_large(b *testing.B) {
+	s := newServiceWithPayments(b, 200_000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.FilterPayments(1, 200_000/8)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkService_FilterPaymentsContext(b *testing.B) {
+	s := newServiceWithPayments(b, 200_000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.FilterPaymentsContext(context.Background(), 1, 8)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkService_FilterPaymentByFn_large(b *testing.B) {
+	s := newServiceWithPayments(b, 200_000)
+	filter := func(payment types.Payment) bool {
+		return payment.Amount > 5
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.FilterPaymentByFn(filter, 200_000/8)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkService_FilterPaymentByFnContext(b *testing.B) {
+	s := newServiceWithPayments(b, 200_000)
+	filter := func(payment types.Payment) bool {
+		return payment.Amount > 5
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.FilterPaymentByFnContext(context.Background(), filter, 8)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestService_SumPaymentsWithProgressContext(t *testing.T) {
+	s := newServiceWithPayments(t, 2_500)
+	want, err := s.SumPayments(100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var last Progress
+	messages := 0
+	for progress := range s.SumPaymentsWithProgressContext(context.Background(), 1_000) {
+		messages++
+		last = progress
+	}
+	if messages != 4 {
+		t.Errorf("SumPaymentsWithProgressContext(): got %d messages, want %d", messages, 4)
+	}
+	if !last.Done || last.Err != nil {
+		t.Errorf("SumPaymentsWithProgressContext(): wrong final message = %v", last)
+		return
+	}
+	if last.Total != want || last.Percent != 100 || last.Completed != 3 {
+		t.Errorf("SumPaymentsWithProgressContext(): final = %v, want total %v", last, want)
+	}
+}
+
+func TestService_SumPaymentsWithProgress_smallData(t *testing.T) {
+	s := newServiceWithPayments(t, 10)
+	var last Progress
+	for progress := range s.SumPaymentsWithProgress() {
+		last = progress
+	}
+	if want, _ := s.SumPayments(100); !last.Done || last.Total != want {
+		t.Errorf("SumPaymentsWithProgress(): final = %v, want total %v", last, want)
+	}
+}
+
+func TestService_SumPaymentsWithProgressContext_cancelled(t *testing.T) {
+	s := newServiceWithPayments(t, 2_500)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var last Progress
+	for progress := range s.SumPaymentsWithProgressContext(ctx, 1_000) {
+		last = progress
+	}
+	if !last.Done || !errors.Is(last.Err, context.Canceled) {
+		t.Errorf("SumPaymentsWithProgressContext(): must report context.Canceled, final = %v", last)
+	}
+}
+
+func TestService_SumPaymentsContext_overflow(t *testing.T) {
+	s := newTestService()
+	for _, phone := range []types.Phone{"+992985570302", "+992981111111"} {
+		account, err := s.addAccountWithBalance(phone, types.MaxMoney)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, err = s.Pay(account.ID, types.MaxMoney, "grocery")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	_, err := s.SumPaymentsContext(context.Background(), 2)
+	if !errors.Is(err, types.ErrMoneyOverflow) {
+		t.Errorf("SumPaymentsContext(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+	_, err = s.SumPayments(1)
+	if !errors.Is(err, types.ErrMoneyOverflow) {
+		t.Errorf("SumPayments(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+	var last Progress
+	for progress := range s.SumPaymentsWithProgressContext(context.Background(), 1) {
+		last = progress
+	}
+	if !errors.Is(last.Err, types.ErrMoneyOverflow) {
+		t.Errorf("SumPaymentsWithProgressContext(): must report ErrMoneyOverflow, got = %v", last.Err)
+	}
+}
@@ -0,0 +1,512 @@
+package wallet
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidPayout = errors.New("invalid payout")
+var ErrPaymentNotPayable = errors.New("payment can't be paid out")
+var ErrInvalidStatusReport = errors.New("invalid payment status report")
+
+// ErrPaymentPaidOut is returned when rejecting a payment sent to the bank in
+// a pain.001 file: only the bank's rejection in a pain.002 report refunds it.
+var ErrPaymentPaidOut = errors.New("payment is paid out")
+
+type PayoutGrouping string
+
+const (
+	PayoutByCategory PayoutGrouping = "category"
+	PayoutByAccount  PayoutGrouping = "account"
+)
+
+// PayoutParty is a bank account holder. Account is used as an IBAN when it
+// looks like one and as a domestic account number otherwise.
+type PayoutParty struct {
+	Name    string
+	Account string
+	BIC     string
+}
+
+// PayoutOptions describe the pain.001 message. Creditor resolves where each
+// payment is paid to. MessageID is generated when empty.
+type PayoutOptions struct {
+	MessageID     string
+	Initiator     string
+	Debtor        PayoutParty
+	Currency      string
+	ExecutionDate time.Time
+	GroupBy       PayoutGrouping
+	Creditor      func(payment types.Payment) (PayoutParty, error)
+}
+
+type pain001Document struct {
+	XMLName  xml.Name       `xml:"urn:iso:std:iso:20022:tech:xsd:pain.001.001.03 Document"`
+	Initiate pain001Message `xml:"CstmrCdtTrfInitn"`
+}
+
+type pain001Message struct {
+	Header  pain001Header  `xml:"GrpHdr"`
+	Batches []pain001Batch `xml:"PmtInf"`
+}
+
+type pain001Header struct {
+	MessageID    string `xml:"MsgId"`
+	Created      string `xml:"CreDtTm"`
+	Transactions int    `xml:"NbOfTxs"`
+	ControlSum   string `xml:"CtrlSum"`
+	Initiator    string `xml:"InitgPty>Nm"`
+}
+
+type pain001Other struct {
+	ID string `xml:"Id"`
+}
+
+type pain001Account struct {
+	IBAN  string        `xml:"Id>IBAN,omitempty"`
+	Other *pain001Other `xml:"Id>Othr,omitempty"`
+}
+
+type pain001Agent struct {
+	BIC   string        `xml:"FinInstnId>BIC,omitempty"`
+	Other *pain001Other `xml:"FinInstnId>Othr,omitempty"`
+}
+
+type pain001Batch struct {
+	ID            string               `xml:"PmtInfId"`
+	Method        string               `xml:"PmtMtd"`
+	Transactions  int                  `xml:"NbOfTxs"`
+	ControlSum    string               `xml:"CtrlSum"`
+	ExecutionDate string               `xml:"ReqdExctnDt"`
+	Debtor        string               `xml:"Dbtr>Nm"`
+	DebtorAccount pain001Account       `xml:"DbtrAcct"`
+	DebtorAgent   pain001Agent         `xml:"DbtrAgt"`
+	Charges       string               `xml:"ChrgBr"`
+	Transfers     []pain001Transaction `xml:"CdtTrfTxInf"`
+}
+
+type pain001Transaction struct {
+	InstructionID   string         `xml:"PmtId>InstrId"`
+	EndToEndID      string         `xml:"PmtId>EndToEndId"`
+	Amount          pain001Amount  `xml:"Amt>InstdAmt"`
+	CreditorAgent   *pain001Agent  `xml:"CdtrAgt,omitempty"`
+	Creditor        string         `xml:"Cdtr>Nm"`
+	CreditorAccount pain001Account `xml:"CdtrAcct"`
+	Remittance      string         `xml:"RmtInf>Ustrd,omitempty"`
+}
+
+type pain001Amount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// PayoutBatch is one PmtInf block of the file: the payments of one group
+// debited together from the debtor account.
+type PayoutBatch struct {
+	ID         string
+	Group      string
+	ControlSum types.Money
+	PaymentIDs []string
+}
+
+// Pain001 is a generated customer credit transfer initiation.
+type Pain001 struct {
+	MessageID  string
+	ControlSum types.Money
+	Batches    []PayoutBatch
+	document   pain001Document
+}
+
+func (p *Pain001) WriteXML(w io.Writer) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(p.document)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+var ibanPattern = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]{1,30}$`)
+var bicPattern = regexp.MustCompile(`^[A-Z]{6}[A-Z2-9][A-NP-Z0-9]([A-Z0-9]{3})?$`)
+
+func payoutAccount(account string) pain001Account {
+	account = strings.ToUpper(strings.ReplaceAll(account, " ", ""))
+	if ibanPattern.MatchString(account) {
+		return pain001Account{IBAN: account}
+	}
+	return pain001Account{Other: &pain001Other{ID: account}}
+}
+
+func payoutAgent(bic string) pain001Agent {
+	if bic == "" {
+		return pain001Agent{Other: &pain001Other{ID: "NOTPROVIDED"}}
+	}
+	return pain001Agent{BIC: bic}
+}
+
+func (p PayoutParty) valid() bool {
+	return p.Name != "" && len(p.Name) <= 140 && p.Account != "" && len(p.Account) <= 34 && (p.BIC == "" || bicPattern.MatchString(p.BIC))
+}
+
+// remittance fits the category in the 140 characters allowed for
+// unstructured remittance information.
+func remittance(category types.PaymentCategory) string {
+	text := []rune(string(category))
+	if len(text) > 140 {
+		text = text[:140]
+	}
+	return string(text)
+}
+
+// endToEndID fits the payment id in the 35 characters allowed.
+func endToEndID(paymentID string) string {
+	return strings.ReplaceAll(paymentID, "-", "")
+}
+
+// paidOut reports whether the payment is in a payout the bank hasn't
+// rejected or cancelled.
+func (s *Service) paidOut(paymentID string) bool {
+	for _, payout := range s.payouts {
+		if payout.PaymentID != paymentID {
+			continue
+		}
+		if status, _ := paymentStatus(payout.Status); status != types.PaymentStatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Pain001 builds a credit transfer file paying out the given payments, one
+// batch per category or account. Only payments in progress that aren't in
+// an earlier file can be paid out; the payouts are recorded so pain.002
+// status reports can be applied with ImportPain002.
+func (s *Service) Pain001(paymentIDs []string, options PayoutOptions) (file *Pain001, err error) {
+	defer func() {
+		result := ""
+		if file != nil {
+			result = "message=" + file.MessageID
+		}
+		s.record("Pain001", auditArgs("payments", len(paymentIDs), "group", options.GroupBy), result, err)
+	}()
+
+	if options.MessageID == "" {
+		options.MessageID = strings.ToUpper(endToEndID(uuid.New().String()))
+	}
+	if options.Currency == "" {
+		options.Currency = "TJS"
+	}
+	if options.GroupBy == "" {
+		options.GroupBy = PayoutByCategory
+	}
+	switch {
+	case len(paymentIDs) == 0 || len(options.MessageID) > 35 || options.Creditor == nil:
+		return nil, ErrInvalidPayout
+	case options.GroupBy != PayoutByCategory && options.GroupBy != PayoutByAccount:
+		return nil, ErrInvalidPayout
+	case !options.Debtor.valid() || len(options.Currency) != 3:
+		return nil, ErrInvalidPayout
+	}
+	now := s.clock().UTC()
+	execution := options.ExecutionDate
+	if execution.IsZero() {
+		execution = now
+	}
+	initiator := options.Initiator
+	if initiator == "" {
+		initiator = options.Debtor.Name
+	}
+
+	batches := make(map[string]*pain001Batch)
+	summaries := make(map[string]*PayoutBatch)
+	var groups []string
+	total := types.Money(0)
+	seen := make(map[string]bool, len(paymentIDs))
+	for _, id := range paymentIDs {
+		payment, err := s.FindPaymentByID(id)
+		if err != nil {
+			return nil, err
+		}
+		if seen[id] || payment.Status != types.PaymentStatusInProgress || payment.RecipientID != 0 || s.paidOut(id) {
+			return nil, fmt.Errorf("%w: %s", ErrPaymentNotPayable, id)
+		}
+		seen[id] = true
+		creditor, err := options.Creditor(*payment)
+		if err != nil {
+			return nil, err
+		}
+		if !creditor.valid() {
+			return nil, fmt.Errorf("%w: creditor of %s", ErrInvalidPayout, id)
+		}
+
+		group := string(payment.Category)
+		if options.GroupBy == PayoutByAccount {
+			group = strconv.FormatInt(payment.AccountID, 10)
+		}
+		batch, ok := batches[group]
+		if !ok {
+			batch = &pain001Batch{
+				Method:        "TRF",
+				ExecutionDate: execution.UTC().Format("2006-01-02"),
+				Debtor:        options.Debtor.Name,
+				DebtorAccount: payoutAccount(options.Debtor.Account),
+				DebtorAgent:   payoutAgent(options.Debtor.BIC),
+				Charges:       "SLEV",
+			}
+			batches[group] = batch
+			summaries[group] = &PayoutBatch{Group: group}
+			groups = append(groups, group)
+		}
+
+		transaction := pain001Transaction{
+			InstructionID:   endToEndID(payment.ID),
+			EndToEndID:      endToEndID(payment.ID),
+			Amount:          pain001Amount{Currency: options.Currency, Value: payment.Amount.Decimal()},
+			Creditor:        creditor.Name,
+			CreditorAccount: payoutAccount(creditor.Account),
+			Remittance:      remittance(payment.Category),
+		}
+		if creditor.BIC != "" {
+			agent := payoutAgent(creditor.BIC)
+			transaction.CreditorAgent = &agent
+		}
+		batch.Transfers = append(batch.Transfers, transaction)
+		summaries[group].ControlSum += payment.Amount
+		summaries[group].PaymentIDs = append(summaries[group].PaymentIDs, payment.ID)
+		total += payment.Amount
+	}
+
+	sort.Strings(groups)
+	document := pain001Document{
+		Initiate: pain001Message{
+			Header: pain001Header{
+				MessageID:    options.MessageID,
+				Created:      now.Format("2006-01-02T15:04:05"),
+				Transactions: len(paymentIDs),
+				ControlSum:   total.Decimal(),
+				Initiator:    initiator,
+			},
+		},
+	}
+	prefix := options.MessageID
+	if len(prefix) > 30 {
+		prefix = prefix[:30]
+	}
+	file = &Pain001{MessageID: options.MessageID, ControlSum: total}
+	for i, group := range groups {
+		batch, summary := batches[group], summaries[group]
+		batch.ID = fmt.Sprintf("%s-%d", prefix, i+1)
+		batch.Transactions = len(batch.Transfers)
+		batch.ControlSum = summary.ControlSum.Decimal()
+		summary.ID = batch.ID
+		document.Initiate.Batches = append(document.Initiate.Batches, *batch)
+		file.Batches = append(file.Batches, *summary)
+		for _, paymentID := range summary.PaymentIDs {
+			s.payouts = append(s.payouts, &types.Payout{
+				PaymentID:  paymentID,
+				MessageID:  options.MessageID,
+				BatchID:    batch.ID,
+				EndToEndID: endToEndID(paymentID),
+				Created:    now,
+			})
+		}
+	}
+	file.document = document
+	return file, nil
+}
+
+type pain002Document struct {
+	Report struct {
+		Group struct {
+			MessageID string `xml:"OrgnlMsgId"`
+			Status    string `xml:"GrpSts"`
+			Reason    string `xml:"StsRsnInf>Rsn>Cd"`
+		} `xml:"OrgnlGrpInfAndSts"`
+		Batches []struct {
+			ID           string `xml:"OrgnlPmtInfId"`
+			Status       string `xml:"PmtInfSts"`
+			Reason       string `xml:"StsRsnInf>Rsn>Cd"`
+			Transactions []struct {
+				EndToEndID string `xml:"OrgnlEndToEndId"`
+				Status     string `xml:"TxSts"`
+				Reason     string `xml:"StsRsnInf>Rsn>Cd"`
+			} `xml:"TxInfAndSts"`
+		} `xml:"OrgnlPmtInfAndSts"`
+	} `xml:"CstmrPmtStsRpt"`
+}
+
+// PayoutStatus is the bank status of one paid out payment and the wallet
+// status it was mapped to.
+type PayoutStatus struct {
+	PaymentID string
+	Code      string
+	Reason    string
+	Status    types.PaymentStatus
+}
+
+// paymentStatus maps an ISO 20022 transaction status code. Only settled and
+// rejected transfers are final; everything else stays in progress.
+func paymentStatus(code string) (types.PaymentStatus, bool) {
+	switch code {
+	case "ACSC", "ACCC":
+		return types.PaymentStatusOk, true
+	case "RJCT", "CANC":
+		return types.PaymentStatusFail, true
+	case "ACCP", "ACSP", "ACTC", "ACWC", "ACWP", "ACFC", "PART", "PDNG", "RCVD":
+		return types.PaymentStatusInProgress, true
+	}
+	return "", false
+}
+
+// ImportPain002 applies a pain.002 status report to the payouts of the
+// original message. Transaction statuses win over batch statuses, which win
+// over the group status. Rejected payouts reject the payment and return the
+// money to the account. The whole report is checked first, so a report with
+// an unknown status code or payment changes nothing.
+func (s *Service) ImportPain002(r io.Reader) (statuses []PayoutStatus, err error) {
+	defer func() {
+		s.record("ImportPain002", auditArgs("statuses", len(statuses)), "", err)
+	}()
+
+	var document pain002Document
+	err = xml.NewDecoder(r).Decode(&document)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidStatusReport, err)
+	}
+	report := document.Report
+	if report.Group.MessageID == "" {
+		return nil, fmt.Errorf("%w: no original message id", ErrInvalidStatusReport)
+	}
+
+	type status struct{ code, reason string }
+	byBatch := make(map[string]status)
+	byTransaction := make(map[string]status)
+	for _, batch := range report.Batches {
+		if batch.Status != "" {
+			byBatch[batch.ID] = status{batch.Status, batch.Reason}
+		}
+		for _, transaction := range batch.Transactions {
+			byTransaction[transaction.EndToEndID] = status{transaction.Status, transaction.Reason}
+		}
+	}
+
+	type change struct {
+		payout  *types.Payout
+		payment *types.Payment
+		update  status
+		mapped  types.PaymentStatus
+	}
+	var changes []change
+	for _, payout := range s.payouts {
+		if payout.MessageID != report.Group.MessageID {
+			continue
+		}
+		update, ok := byTransaction[payout.EndToEndID]
+		if !ok {
+			update, ok = byBatch[payout.BatchID]
+		}
+		if !ok && report.Group.Status != "" {
+			update, ok = status{report.Group.Status, report.Group.Reason}, true
+		}
+		if !ok {
+			continue
+		}
+		mapped, known := paymentStatus(update.code)
+		if !known {
+			return nil, fmt.Errorf("%w: status %q", ErrInvalidStatusReport, update.code)
+		}
+		payment, err := s.FindPaymentByID(payout.PaymentID)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change{payout, payment, update, mapped})
+	}
+
+	for _, change := range changes {
+		payment := change.payment
+		switch {
+		case payment.Status == types.PaymentStatusFail:
+		case change.mapped == types.PaymentStatusFail:
+			// the payout must be failed first, rejectPayment refuses paid out payments
+			previous := change.payout.Status
+			change.payout.Status = change.update.code
+			err = s.reject(payment.ID)
+			if err != nil {
+				change.payout.Status = previous
+				return statuses, err
+			}
+		default:
+			payment.Status = change.mapped
+		}
+		change.payout.Status = change.update.code
+		change.payout.Reason = change.update.reason
+		change.payout.Updated = s.clock().UTC()
+		statuses = append(statuses, PayoutStatus{
+			PaymentID: payment.ID,
+			Code:      change.update.code,
+			Reason:    change.update.reason,
+			Status:    payment.Status,
+		})
+	}
+	return statuses, nil
+}
+
+func (s *Service) Payouts(paymentID string) []types.Payout {
+	var payouts []types.Payout
+	for _, payout := range s.payouts {
+		if payout.PaymentID == paymentID {
+			payouts = append(payouts, *payout)
+		}
+	}
+	return payouts
+}
+
+func payoutRows(payouts []*types.Payout) [][]string {
+	rows := make([][]string, len(payouts))
+	for i, payout := range payouts {
+		rows[i] = []string{
+			payout.PaymentID,
+			payout.MessageID,
+			payout.BatchID,
+			payout.EndToEndID,
+			payout.Status,
+			payout.Reason,
+			formatTime(payout.Created),
+			formatTime(payout.Updated),
+		}
+	}
+	return rows
+}
+
+func parsePayouts(rows [][]string) []*types.Payout {
+	payouts := make([]*types.Payout, 0, len(rows))
+	for _, row := range rows {
+		payouts = append(payouts, &types.Payout{
+			PaymentID:  row[0],
+			MessageID:  dumpField(row, 1),
+			BatchID:    dumpField(row, 2),
+			EndToEndID: dumpField(row, 3),
+			Status:     dumpField(row, 4),
+			Reason:     dumpField(row, 5),
+			Created:    parseTime(dumpField(row, 6)),
+			Updated:    parseTime(dumpField(row, 7)),
+		})
+	}
+	return payouts
+}
@@ -0,0 +1,179 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+var testDebtor = PayoutParty{Name: "Wallet LLC", Account: "20202972100000001234", BIC: "TJBKTJ22"}
+
+func testCreditor(payment types.Payment) (PayoutParty, error) {
+	return PayoutParty{Name: "Merchant " + string(payment.Category), Account: "DE89 3704 0044 0532 0130 00"}, nil
+}
+
+func TestService_Pain001(t *testing.T) {
+	s := newTestService()
+	s.now = func() time.Time { return time.Date(2022, 8, 1, 9, 30, 0, 0, time.UTC) }
+	_, err := s.addAccountWithBalance("+992985570302", 100_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var ids []string
+	for _, data := range []struct {
+		amount   types.Money
+		category types.PaymentCategory
+	}{{1_050, "auto"}, {2_000, "food"}, {300, "auto"}} {
+		payment, err := s.Pay(1, data.amount, data.category)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ids = append(ids, payment.ID)
+	}
+
+	file, err := s.Pain001(ids, PayoutOptions{MessageID: "MSG-1", Debtor: testDebtor, Creditor: testCreditor})
+	if err != nil {
+		t.Errorf("Pain001(): error = %v", err)
+		return
+	}
+	if file.ControlSum != 3_350 || len(file.Batches) != 2 {
+		t.Errorf("Pain001(): got %v", file)
+		return
+	}
+	if file.Batches[0].Group != "auto" || file.Batches[0].ControlSum != 1_350 || len(file.Batches[0].PaymentIDs) != 2 {
+		t.Errorf("Pain001(): first batch = %v", file.Batches[0])
+	}
+
+	var buf bytes.Buffer
+	err = file.WriteXML(&buf)
+	if err != nil {
+		t.Errorf("WriteXML(): error = %v", err)
+		return
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.001.001.03">`,
+		`<NbOfTxs>3</NbOfTxs>`,
+		`<CtrlSum>33.50</CtrlSum>`,
+		`<PmtInfId>MSG-1-1</PmtInfId>`,
+		`<CtrlSum>13.50</CtrlSum>`,
+		`<InstdAmt Ccy="TJS">10.50</InstdAmt>`,
+		`<IBAN>DE89370400440532013000</IBAN>`,
+		`<Othr>`,
+		`<ReqdExctnDt>2022-08-01</ReqdExctnDt>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("WriteXML(): output has no %s:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "<Othr></Othr>") {
+		t.Errorf("WriteXML(): output has empty elements:\n%s", out)
+	}
+	var decoded pain001Document
+	if err := xml.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Initiate.Batches) != 2 {
+		t.Errorf("WriteXML(): output doesn't decode, error = %v", err)
+	}
+
+	_, err = s.Pain001(ids[:1], PayoutOptions{Debtor: testDebtor, Creditor: testCreditor})
+	if err == nil {
+		t.Error("Pain001(): must not pay out the same payment twice")
+	}
+}
+
+func TestRemittance(t *testing.T) {
+	long := types.PaymentCategory(strings.Repeat("ж", 150))
+	if got := remittance(long); len([]rune(got)) != 140 {
+		t.Errorf("remittance(): got %d characters, want 140", len([]rune(got)))
+	}
+	if got := remittance("auto"); got != "auto" {
+		t.Errorf("remittance(): got %q", got)
+	}
+}
+
+func TestService_ImportPain002(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992985570302", 100_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var ids []string
+	for _, category := range []types.PaymentCategory{"auto", "auto", "food"} {
+		payment, err := s.Pay(1, 1_000, category)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ids = append(ids, payment.ID)
+	}
+	file, err := s.Pain001(ids, PayoutOptions{MessageID: "MSG-2", Debtor: testDebtor, Creditor: testCreditor})
+	if err != nil {
+		t.Errorf("Pain001(): error = %v", err)
+		return
+	}
+	if err := s.Reject(ids[1]); err != ErrPaymentPaidOut {
+		t.Errorf("Reject(): must return ErrPaymentPaidOut, returned = %v", err)
+	}
+
+	report := `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.002.001.03">
+  <CstmrPmtStsRpt>
+    <OrgnlGrpInfAndSts><OrgnlMsgId>MSG-2</OrgnlMsgId></OrgnlGrpInfAndSts>
+    <OrgnlPmtInfAndSts>
+      <OrgnlPmtInfId>` + file.Batches[0].ID + `</OrgnlPmtInfId>
+      <PmtInfSts>ACSC</PmtInfSts>
+      <TxInfAndSts>
+        <OrgnlEndToEndId>` + endToEndID(ids[1]) + `</OrgnlEndToEndId>
+        <TxSts>RJCT</TxSts>
+        <StsRsnInf><Rsn><Cd>AC04</Cd></Rsn></StsRsnInf>
+      </TxInfAndSts>
+    </OrgnlPmtInfAndSts>
+    <OrgnlPmtInfAndSts>
+      <OrgnlPmtInfId>` + file.Batches[1].ID + `</OrgnlPmtInfId>
+      <PmtInfSts>ACSP</PmtInfSts>
+    </OrgnlPmtInfAndSts>
+  </CstmrPmtStsRpt>
+</Document>`
+	// an unknown code later in the report must not leave the rejection applied
+	_, err = s.ImportPain002(strings.NewReader(strings.Replace(report, "ACSP", "XXXX", 1)))
+	if !errors.Is(err, ErrInvalidStatusReport) {
+		t.Errorf("ImportPain002(): must return ErrInvalidStatusReport, returned = %v", err)
+	}
+	if payment, _ := s.FindPaymentByID(ids[1]); payment.Status == types.PaymentStatusFail || account.Balance != 100_000-3_000 {
+		t.Errorf("ImportPain002(): invalid report was applied, payment = %v, balance = %d", payment, account.Balance)
+	}
+
+	statuses, err := s.ImportPain002(strings.NewReader(report))
+	if err != nil {
+		t.Errorf("ImportPain002(): error = %v", err)
+		return
+	}
+	if len(statuses) != 3 {
+		t.Errorf("ImportPain002(): got %v", statuses)
+		return
+	}
+	want := map[string]types.PaymentStatus{
+		ids[0]: types.PaymentStatusOk,
+		ids[1]: types.PaymentStatusFail,
+		ids[2]: types.PaymentStatusInProgress,
+	}
+	for id, status := range want {
+		payment, _ := s.FindPaymentByID(id)
+		if payment.Status != status {
+			t.Errorf("ImportPain002(): payment %s status = %s, want %s", id, payment.Status, status)
+		}
+	}
+	if account.Balance != 100_000-2_000 {
+		t.Errorf("ImportPain002(): rejected payout must be refunded, balance = %d", account.Balance)
+	}
+	if payouts := s.Payouts(ids[1]); len(payouts) != 1 || payouts[0].Reason != "AC04" {
+		t.Errorf("Payouts(): got %v", payouts)
+	}
+}
@@ -0,0 +1,235 @@
+package wallet
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+var ErrInvalidPhone = errors.New("invalid phone number")
+var ErrInvalidCountryCode = errors.New("invalid country code")
+
+const DefaultCountryCode = "992"
+
+// nationalLengths lists the national number length for country codes we
+// can check exactly. Other codes only get the generic E.164 length check.
+var nationalLengths = map[string]int{
+	"1":   10,
+	"7":   10,
+	"44":  10,
+	"90":  10,
+	"992": 9,
+	"993": 8,
+	"996": 9,
+	"998": 9,
+}
+
+func knownCountryCode(digits string) (string, bool) {
+	for size := 1; size <= 3 && size <= len(digits); size++ {
+		if _, ok := nationalLengths[digits[:size]]; ok {
+			return digits[:size], true
+		}
+	}
+	return "", false
+}
+
+// NormalizePhone converts a phone number to E.164 ("+992985570302").
+// Numbers without an international prefix are read in defaultCountry.
+func NormalizePhone(phone types.Phone, defaultCountry string) (types.Phone, error) {
+	raw := strings.TrimSpace(string(phone))
+	var digits strings.Builder
+	for i, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+
+	number := digits.String()
+	switch {
+	case strings.HasPrefix(raw, "+"):
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	case defaultCountry != "":
+		national, ok := nationalLengths[defaultCountry]
+		switch {
+		case ok && len(number) == national:
+			number = defaultCountry + number
+		case ok && len(number) == len(defaultCountry)+national && strings.HasPrefix(number, defaultCountry):
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", ErrInvalidPhone
+	}
+	if code, ok := knownCountryCode(number); ok && len(number) != len(code)+nationalLengths[code] {
+		return "", ErrInvalidPhone
+	}
+	return types.Phone("+" + number), nil
+}
+
+// SetDefaultCountryCode sets the country of numbers given without one, "992"
+// or "+992". Only codes in nationalLengths are accepted: national numbers of
+// other countries can't be told apart from international ones. An empty
+// code restores DefaultCountryCode.
+func (s *Service) SetDefaultCountryCode(code string) (err error) {
+	defer func() {
+		s.record("SetDefaultCountryCode", auditArgs("code", code), "", err)
+	}()
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		s.defaultCountryCode = ""
+		return nil
+	}
+	code = strings.TrimPrefix(code, "+")
+	if _, ok := nationalLengths[code]; !ok {
+		return ErrInvalidCountryCode
+	}
+	s.defaultCountryCode = code
+	return nil
+}
+
+func (s *Service) countryCode() string {
+	if s.defaultCountryCode == "" {
+		return DefaultCountryCode
+	}
+	return s.defaultCountryCode
+}
+
+// phoneKey is the form used to compare phones. Imported accounts may hold
+// numbers that don't parse; those compare by their raw value.
+func (s *Service) phoneKey(phone types.Phone) types.Phone {
+	normalized, err := NormalizePhone(phone, s.countryCode())
+	if err != nil {
+		return phone
+	}
+	return normalized
+}
+
+type PhoneDuplicate struct {
+	Phone      types.Phone
+	AccountIDs []int64
+}
+
+type PhoneReport struct {
+	Duplicates []PhoneDuplicate
+	Invalid    []int64
+}
+
+// PhoneReport finds accounts, typically loaded with Import, whose phones are
+// invalid or normalize to a number already used by another account.
+func (s *Service) PhoneReport() PhoneReport {
+	report := PhoneReport{}
+	byPhone := make(map[types.Phone][]int64)
+	for _, account := range s.accounts {
+		normalized, err := NormalizePhone(account.Phone, s.countryCode())
+		if err != nil {
+			report.Invalid = append(report.Invalid, account.ID)
+			continue
+		}
+		byPhone[normalized] = append(byPhone[normalized], account.ID)
+	}
+	for phone, ids := range byPhone {
+		if len(ids) > 1 {
+			report.Duplicates = append(report.Duplicates, PhoneDuplicate{Phone: phone, AccountIDs: ids})
+		}
+	}
+	sort.Slice(report.Duplicates, func(i, j int) bool {
+		return report.Duplicates[i].Phone < report.Duplicates[j].Phone
+	})
+	return report
+}
+
+func (s *Service) FindAccountByPhone(phone types.Phone) (*types.Account, error) {
+	normalized, err := NormalizePhone(phone, s.countryCode())
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range s.accounts {
+		if s.phoneKey(account.Phone) == normalized {
+			return account, nil
+		}
+	}
+
+	return nil, ErrAccountNotFound
+}
+
+func (s *Service) ChangePhone(accountID int64, phone types.Phone) (err error) {
+	defer func() {
+		s.record("ChangePhone", auditArgs("account", accountID, "phone", phone), "", err)
+	}()
+
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+	normalized, err := NormalizePhone(phone, s.countryCode())
+	if err != nil {
+		return err
+	}
+	if s.phoneKey(account.Phone) == normalized {
+		return nil
+	}
+	if _, err := s.FindAccountByPhone(normalized); err == nil {
+		return ErrPhoneRegistered
+	}
+	if s.phoneBlocked(normalized) {
+		s.screeningMatch(accountID, normalized, "", "ChangePhone")
+		return ErrBlockedPhone
+	}
+
+	s.phoneChanges = append(s.phoneChanges, &types.PhoneChange{
+		AccountID: accountID,
+		Old:       account.Phone,
+		New:       normalized,
+		Changed:   s.clock().UTC(),
+	})
+	account.Phone = normalized
+	return nil
+}
+
+func (s *Service) PhoneHistory(accountID int64) ([]types.PhoneChange, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	var changes []types.PhoneChange
+	for _, change := range s.phoneChanges {
+		if change.AccountID == accountID {
+			changes = append(changes, *change)
+		}
+	}
+	return changes, nil
+}
+
+func phoneChangeRows(changes []*types.PhoneChange) [][]string {
+	rows := make([][]string, len(changes))
+	for i, change := range changes {
+		rows[i] = []string{strconv.FormatInt(change.AccountID, 10), string(change.Old), string(change.New), formatTime(change.Changed)}
+	}
+	return rows
+}
+
+func parsePhoneChanges(rows [][]string) []*types.PhoneChange {
+	changes := make([]*types.PhoneChange, 0, len(rows))
+	for _, row := range rows {
+		accID, _ := strconv.ParseInt(row[0], 10, 64)
+		changes = append(changes, &types.PhoneChange{
+			AccountID: accID,
+			Old:       types.Phone(dumpField(row, 1)),
+			New:       types.Phone(dumpField(row, 2)),
+			Changed:   parseTime(dumpField(row, 3)),
+		})
+	}
+	return changes
+}
@@ -0,0 +1,95 @@
+package wallet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone types.Phone
+		want  types.Phone
+		err   error
+	}{
+		{"+992985570302", "+992985570302", nil},
+		{"992985570302", "+992985570302", nil},
+		{"985570302", "+992985570302", nil},
+		{"(98) 557-03-02", "+992985570302", nil},
+		{"00992985570302", "+992985570302", nil},
+		{"+79161234567", "+79161234567", nil},
+		{"+99298557030", "", ErrInvalidPhone},
+		{"98557030", "", ErrInvalidPhone},
+		{"+992985570302x", "", ErrInvalidPhone},
+		{"", "", ErrInvalidPhone},
+	}
+	for _, tt := range tests {
+		got, err := NormalizePhone(tt.phone, "992")
+		if got != tt.want || err != tt.err {
+			t.Errorf("NormalizePhone(%q) = %q, %v, want %q, %v", tt.phone, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestService_RegisterAccount_normalizedDuplicates(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("985570302")
+	if err != nil {
+		t.Errorf("RegisterAccount(): error = %v", err)
+		return
+	}
+	if account.Phone != "+992985570302" {
+		t.Errorf("RegisterAccount(): phone not normalized, account = %v", account)
+	}
+	for _, phone := range []types.Phone{"+992985570302", "992985570302", "992 98 557 03 02"} {
+		_, err = s.RegisterAccount(phone)
+		if err != ErrPhoneRegistered {
+			t.Errorf("RegisterAccount(%q): must return ErrPhoneRegistered, returned = %v", phone, err)
+		}
+	}
+	_, err = s.RegisterAccount("12345")
+	if err != ErrInvalidPhone {
+		t.Errorf("RegisterAccount(): must return ErrInvalidPhone, returned = %v", err)
+	}
+}
+
+func TestService_PhoneReport(t *testing.T) {
+	s := newTestService()
+	s.accounts = []*types.Account{
+		{ID: 1, Phone: "+992985570302"},
+		{ID: 2, Phone: "985570302"},
+		{ID: 3, Phone: "+992981111111"},
+		{ID: 4, Phone: "not a phone"},
+		{ID: 5, Phone: "992985570302"},
+	}
+	got := s.PhoneReport()
+	want := PhoneReport{
+		Duplicates: []PhoneDuplicate{{Phone: "+992985570302", AccountIDs: []int64{1, 2, 5}}},
+		Invalid:    []int64{4},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PhoneReport(): got %v, want %v", got, want)
+	}
+}
+
+func TestService_SetDefaultCountryCode(t *testing.T) {
+	s := newTestService()
+	for _, code := range []string{"abc", "999", "+"} {
+		if err := s.SetDefaultCountryCode(code); err != ErrInvalidCountryCode {
+			t.Errorf("SetDefaultCountryCode(%q): must return ErrInvalidCountryCode, returned = %v", code, err)
+		}
+	}
+	if s.countryCode() != DefaultCountryCode {
+		t.Errorf("SetDefaultCountryCode(): invalid code was kept, got %s", s.countryCode())
+	}
+	err := s.SetDefaultCountryCode("+998")
+	if err != nil {
+		t.Errorf("SetDefaultCountryCode(): error = %v", err)
+		return
+	}
+	account, err := s.RegisterAccount("901234567")
+	if err != nil || account.Phone != "+998901234567" {
+		t.Errorf("RegisterAccount(): account = %v, error = %v", account, err)
+	}
+}
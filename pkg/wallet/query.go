@@ -0,0 +1,296 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+type Filter func(payment types.Payment) bool
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	pos   int
+	value string
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case strings.ContainsRune("=!<>", r):
+			start := i
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				i++
+			}
+			op := string(runes[start:i])
+			if op == "!" {
+				return nil, fmt.Errorf("%w: unexpected %q at %d", ErrInvalidFilter, op, start)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, pos: start})
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			var value strings.Builder
+			for ; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("%w: unterminated string at %d", ErrInvalidFilter, start)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: string(runes[start:i]), pos: start, value: value.String()})
+		case unicode.IsDigit(r) || r == '-':
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			text := string(runes[start:i])
+			tokens = append(tokens, token{kind: tokenNumber, text: text, pos: start, value: strings.ReplaceAll(text, "_", "")})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			text := string(runes[start:i])
+			tokens = append(tokens, token{kind: tokenWord, text: text, pos: start, value: text})
+		default:
+			return nil, fmt.Errorf("%w: unexpected %q at %d", ErrInvalidFilter, r, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *filterParser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenWord && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) errorf(t token, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s at %d", ErrInvalidFilter, fmt.Sprintf(format, args...), t.pos)
+}
+
+// ParseFilter compiles an expression such as
+//
+//	category = "grocery" AND amount > 1000 AND NOT status = FAIL
+//
+// into a Filter. Fields are id, account, amount, category, status and time;
+// AND binds tighter than OR and parentheses group.
+func ParseFilter(expr string) (Filter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return filter, nil
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		a, b := left, right
+		left = func(payment types.Payment) bool { return a(payment) || b(payment) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		a, b := left, right
+		left = func(payment types.Payment) bool { return a(payment) && b(payment) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (Filter, error) {
+	if p.keyword("NOT") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(payment types.Payment) bool { return !inner(payment) }, nil
+	}
+	if p.peek().kind == tokenLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, p.errorf(t, "expected )")
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (Filter, error) {
+	field := p.next()
+	if field.kind != tokenWord {
+		return nil, p.errorf(field, "expected field name")
+	}
+	op := p.next()
+	if op.kind != tokenOperator {
+		return nil, p.errorf(op, "expected comparison operator")
+	}
+	value := p.next()
+	if value.kind != tokenWord && value.kind != tokenString && value.kind != tokenNumber {
+		return nil, p.errorf(value, "expected value")
+	}
+
+	switch strings.ToLower(field.text) {
+	case "id":
+		return compareStrings(op.text, value.value, func(payment types.Payment) string { return payment.ID }), nil
+	case "category":
+		return compareStrings(op.text, value.value, func(payment types.Payment) string { return string(payment.Category) }), nil
+	case "status":
+		return compareStrings(op.text, strings.ToUpper(value.value), func(payment types.Payment) string { return string(payment.Status) }), nil
+	case "amount", "account":
+		number, err := strconv.ParseInt(value.value, 10, 64)
+		if err != nil {
+			return nil, p.errorf(value, "expected number")
+		}
+		get := func(payment types.Payment) int64 { return int64(payment.Amount) }
+		if strings.EqualFold(field.text, "account") {
+			get = func(payment types.Payment) int64 { return payment.AccountID }
+		}
+		return compareInts(op.text, number, get), nil
+	case "time":
+		at, err := parseFilterTime(value.value)
+		if err != nil {
+			return nil, p.errorf(value, "expected time as 2006-01-02 or RFC 3339")
+		}
+		return compareInts(op.text, at.UnixNano(), func(payment types.Payment) int64 { return unixNano(payment.Created) }), nil
+	}
+	return nil, p.errorf(field, "unknown field %q", field.text)
+}
+
+func parseFilterTime(value string) (time.Time, error) {
+	if at, err := time.Parse("2006-01-02", value); err == nil {
+		return at, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func compareStrings(op string, want string, get func(payment types.Payment) string) Filter {
+	return func(payment types.Payment) bool {
+		return compare(op, strings.Compare(get(payment), want))
+	}
+}
+
+func compareInts(op string, want int64, get func(payment types.Payment) int64) Filter {
+	return func(payment types.Payment) bool {
+		got := get(payment)
+		switch {
+		case got < want:
+			return compare(op, -1)
+		case got > want:
+			return compare(op, 1)
+		}
+		return compare(op, 0)
+	}
+}
+
+func compare(op string, cmp int) bool {
+	switch op {
+	case "=", "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func (s *Service) QueryPayments(expr string, goroutines int) ([]types.Payment, error) {
+	filter, err := ParseFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	return s.FilterPaymentByFn(filter, goroutines)
+}
@@ -0,0 +1,65 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestParseFilter(t *testing.T) {
+	grocery := types.Payment{Amount: 1500, Category: "grocery", Status: types.PaymentStatusOk, AccountID: 1}
+	auto := types.Payment{Amount: 500, Category: "auto", Status: types.PaymentStatusFail, AccountID: 2}
+
+	tests := []struct {
+		expr  string
+		match []bool
+	}{
+		{`category = "grocery" AND amount > 1000`, []bool{true, false}},
+		{`category = 'auto' OR amount >= 1_500`, []bool{true, true}},
+		{`NOT status = fail`, []bool{true, false}},
+		{`account != 1 AND (amount < 1000 OR category = grocery)`, []bool{false, true}},
+		{`amount <= 500 or account == 1`, []bool{true, true}},
+	}
+	for _, tt := range tests {
+		filter, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): error = %v", tt.expr, err)
+			continue
+		}
+		for i, payment := range []types.Payment{grocery, auto} {
+			if got := filter(payment); got != tt.match[i] {
+				t.Errorf("ParseFilter(%q): payment %v matched = %v, want %v", tt.expr, payment, got, tt.match[i])
+			}
+		}
+	}
+}
+
+func TestParseFilter_invalid(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`amount >`,
+		`amount > "ten"`,
+		`color = red`,
+		`(amount > 1`,
+		`category = "grocery`,
+		`amount > 1 amount < 2`,
+	} {
+		_, err := ParseFilter(expr)
+		if !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("ParseFilter(%q): must return ErrInvalidFilter, returned = %v", expr, err)
+		}
+	}
+}
+
+func TestService_QueryPayments(t *testing.T) {
+	s := newServiceWithPayments(t, 100)
+	got, err := s.QueryPayments(`account = 2 AND amount > 8`, 10)
+	if err != nil {
+		t.Errorf("QueryPayments(): error = %v", err)
+		return
+	}
+	if len(got) != 10 {
+		t.Errorf("QueryPayments(): got %d payments, want %d", len(got), 10)
+	}
+}
@@ -0,0 +1,389 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+var ErrInvalidStatement = errors.New("invalid bank statement")
+
+// BankEntry is one booking on the bank statement. Amount is in minor units
+// and signed like LedgerEntry: money received is positive, money paid out
+// negative.
+type BankEntry struct {
+	Date        time.Time   `json:"date"`
+	Amount      types.Money `json:"amount"`
+	Reference   string      `json:"reference"`
+	Description string      `json:"description,omitempty"`
+}
+
+// parseDecimal reads a bank amount such as "-1 234.50" or "1.234,50" into
+// minor units, taking the last separator as the decimal one.
+func parseDecimal(value string) (types.Money, error) {
+	locale := types.LocaleEnglish
+	if strings.LastIndex(value, ",") > strings.LastIndex(value, ".") {
+		locale = types.Locale{Decimal: ',', Group: '.'}
+	}
+	amount, err := types.ParseMoney(strings.ReplaceAll(value, "'", ""), locale)
+	if err != nil {
+		return 0, fmt.Errorf("%w: amount %q", ErrInvalidStatement, value)
+	}
+	return amount, nil
+}
+
+func parseBankDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"2006-01-02", time.RFC3339, "2006-01-02T15:04:05", "02.01.2006"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: date %q", ErrInvalidStatement, value)
+}
+
+// ReadBankCSV reads a statement with a header naming the date, amount and
+// reference columns; description is optional.
+func ReadBankCSV(r io.Reader) ([]BankEntry, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidStatement, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"date", "amount", "reference"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: missing %s column", ErrInvalidStatement, name)
+		}
+	}
+
+	var entries []BankEntry
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidStatement, err)
+		}
+		date, err := parseBankDate(row[columns["date"]])
+		if err != nil {
+			return nil, err
+		}
+		amount, err := parseDecimal(row[columns["amount"]])
+		if err != nil {
+			return nil, err
+		}
+		entry := BankEntry{
+			Date:      date,
+			Amount:    amount,
+			Reference: strings.TrimSpace(row[columns["reference"]]),
+		}
+		if i, ok := columns["description"]; ok {
+			entry.Description = row[i]
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+type camtDate struct {
+	Dt   string `xml:"Dt"`
+	DtTm string `xml:"DtTm"`
+}
+
+func (d camtDate) value() string {
+	if d.Dt != "" {
+		return d.Dt
+	}
+	return d.DtTm
+}
+
+type camtDocument struct {
+	Statements []struct {
+		Entries []struct {
+			Amount    string   `xml:"Amt"`
+			Indicator string   `xml:"CdtDbtInd"`
+			Booking   camtDate `xml:"BookgDt"`
+			Value     camtDate `xml:"ValDt"`
+			Reference string   `xml:"NtryRef"`
+			Details   []struct {
+				EndToEndID string   `xml:"Refs>EndToEndId"`
+				Remittance []string `xml:"RmtInf>Ustrd"`
+			} `xml:"NtryDtls>TxDtls"`
+			Info string `xml:"AddtlNtryInf"`
+		} `xml:"Ntry"`
+	} `xml:"BkToCstmrStmt>Stmt"`
+}
+
+// ReadCamt053 reads the entries of an ISO 20022 camt.053 statement. The
+// reference is the end-to-end id, falling back to the remittance text and
+// then the entry reference.
+func ReadCamt053(r io.Reader) ([]BankEntry, error) {
+	var document camtDocument
+	err := xml.NewDecoder(r).Decode(&document)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidStatement, err)
+	}
+
+	var entries []BankEntry
+	for _, statement := range document.Statements {
+		for _, ntry := range statement.Entries {
+			amount, err := parseDecimal(ntry.Amount)
+			if err != nil {
+				return nil, err
+			}
+			if ntry.Indicator == "DBIT" {
+				amount = -amount
+			}
+			day := ntry.Booking.value()
+			if day == "" {
+				day = ntry.Value.value()
+			}
+			date, err := parseBankDate(day)
+			if err != nil {
+				return nil, err
+			}
+
+			entry := BankEntry{Date: date, Amount: amount, Reference: ntry.Reference, Description: ntry.Info}
+			for _, details := range ntry.Details {
+				if details.EndToEndID != "" && details.EndToEndID != "NOTPROVIDED" {
+					entry.Reference = details.EndToEndID
+					break
+				}
+				if len(details.Remittance) > 0 {
+					entry.Reference = strings.TrimSpace(details.Remittance[0])
+					break
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// ReadBankStatementFile reads camt.053 from .xml files and CSV otherwise.
+func ReadBankStatementFile(path string) ([]BankEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Print(cerr)
+		}
+	}()
+
+	if strings.EqualFold(filepath.Ext(path), ".xml") {
+		return ReadCamt053(file)
+	}
+	return ReadBankCSV(file)
+}
+
+// ReconciliationItem pairs a bank entry with a wallet deposit or payment.
+// Either side is empty for unmatched items.
+type ReconciliationItem struct {
+	Bank      *BankEntry  `json:"bank,omitempty"`
+	Kind      EntryKind   `json:"kind,omitempty"`
+	WalletID  string      `json:"walletId,omitempty"`
+	AccountID int64       `json:"accountId,omitempty"`
+	Amount    types.Money `json:"amount,omitempty"`
+	Time      time.Time   `json:"time,omitempty"`
+	Reason    string      `json:"reason,omitempty"`
+}
+
+// Reconciliation reports bank entries matched to wallet records, entries
+// whose reference names a wallet record that differs in amount or date, and
+// what is left unmatched on either side.
+type Reconciliation struct {
+	Matched         []ReconciliationItem `json:"matched"`
+	Mismatched      []ReconciliationItem `json:"mismatched"`
+	UnmatchedBank   []ReconciliationItem `json:"unmatchedBank"`
+	UnmatchedWallet []ReconciliationItem `json:"unmatchedWallet"`
+}
+
+// bankMovement reports whether a deposit or payment moves money through the
+// bank. Transfers, cashback, vouchers, opening balances and internal charges
+// stay in the wallet.
+func bankMovement(source string, category types.PaymentCategory, recipient int64) bool {
+	switch {
+	case recipient != 0:
+		return false
+	case category == TransferCategory || chargeCategory(category):
+		return false
+	case internalSource(source):
+		return false
+	}
+	return true
+}
+
+func (s *Service) bankItems() []ReconciliationItem {
+	var items []ReconciliationItem
+	for _, deposit := range s.deposits {
+		if deposit.Reversed.IsZero() && bankMovement(deposit.Source, "", 0) {
+			items = append(items, ReconciliationItem{Kind: EntryDeposit, WalletID: deposit.ID, AccountID: deposit.AccountID, Amount: deposit.Amount, Time: deposit.Created})
+		}
+	}
+	for _, payment := range s.payments {
+		if payment.Status != types.PaymentStatusFail && bankMovement("", payment.Category, payment.RecipientID) {
+			items = append(items, ReconciliationItem{Kind: EntryPayment, WalletID: payment.ID, AccountID: payment.AccountID, Amount: -payment.Amount, Time: payment.Created})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Time.Before(items[j].Time)
+	})
+	return items
+}
+
+func dateDistance(a time.Time, b time.Time) time.Duration {
+	if a.After(b) {
+		return a.Sub(b)
+	}
+	return b.Sub(a)
+}
+
+// booked reports whether a wallet record at the given time can be the bank
+// entry booked on date. Bank dates carry no time of day, so the whole day
+// plus tolerance on either side counts.
+func booked(at time.Time, date time.Time, tolerance time.Duration) bool {
+	return !at.Before(date.Add(-tolerance)) && at.Before(date.Add(24*time.Hour+tolerance))
+}
+
+// Reconcile matches bank entries to wallet deposits and payments. An entry
+// whose reference is a wallet deposit or payment id is paired with it;
+// others are paired with the closest unmatched wallet record of the same
+// amount within tolerance of the booking date. References match wallet
+// records of any date, so a late booking is reported as a date mismatch;
+// unmatched wallet records are only reported from tolerance before the first
+// entry to tolerance after the last one.
+func (s *Service) Reconcile(entries []BankEntry, tolerance time.Duration) *Reconciliation {
+	result := &Reconciliation{}
+	if len(entries) == 0 {
+		return result
+	}
+	from, to := entries[0].Date, entries[0].Date
+	for _, entry := range entries {
+		if entry.Date.Before(from) {
+			from = entry.Date
+		}
+		if entry.Date.After(to) {
+			to = entry.Date
+		}
+	}
+	from, to = from.Add(-tolerance), to.Add(24*time.Hour+tolerance)
+	within := func(at time.Time) bool { return !at.Before(from) && at.Before(to) }
+
+	// references are looked up among all records, amounts only among the
+	// records of the statement period
+	items := s.bankItems()
+	used := make([]bool, len(items))
+	byID := make(map[string]int, len(items))
+	byAmount := make(map[types.Money][]int)
+	for j, item := range items {
+		byID[item.WalletID] = j
+		if within(item.Time) {
+			byAmount[item.Amount] = append(byAmount[item.Amount], j)
+		}
+	}
+
+	var pending []int
+	for i := range entries {
+		entry := &entries[i]
+		index, ok := byID[entry.Reference]
+		if entry.Reference == "" || !ok || used[index] {
+			pending = append(pending, i)
+			continue
+		}
+
+		used[index] = true
+		item := items[index]
+		item.Bank = entry
+		switch {
+		case item.Amount != entry.Amount:
+			item.Reason = fmt.Sprintf("amount %s, bank %s", item.Amount.Decimal(), entry.Amount.Decimal())
+			result.Mismatched = append(result.Mismatched, item)
+		case !booked(item.Time, entry.Date, tolerance):
+			item.Reason = fmt.Sprintf("date %s, bank %s", item.Time.Format("2006-01-02"), entry.Date.Format("2006-01-02"))
+			result.Mismatched = append(result.Mismatched, item)
+		default:
+			item.Reason = "reference"
+			result.Matched = append(result.Matched, item)
+		}
+	}
+
+	for _, i := range pending {
+		entry := &entries[i]
+		index := -1
+		for _, j := range byAmount[entry.Amount] {
+			item := items[j]
+			if used[j] || !booked(item.Time, entry.Date, tolerance) {
+				continue
+			}
+			if index < 0 || dateDistance(item.Time, entry.Date) < dateDistance(items[index].Time, entry.Date) {
+				index = j
+			}
+		}
+		if index < 0 {
+			result.UnmatchedBank = append(result.UnmatchedBank, ReconciliationItem{Bank: entry})
+			continue
+		}
+		used[index] = true
+		item := items[index]
+		item.Bank = entry
+		item.Reason = "amount and date"
+		result.Matched = append(result.Matched, item)
+	}
+
+	for j, item := range items {
+		if !used[j] && within(item.Time) {
+			result.UnmatchedWallet = append(result.UnmatchedWallet, item)
+		}
+	}
+	return result
+}
+
+func (r *Reconciliation) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	section := func(title string, items []ReconciliationItem) {
+		fmt.Fprintf(tw, "%s: %d\n", title, len(items))
+		for _, item := range items {
+			bank := "\t\t"
+			if item.Bank != nil {
+				bank = fmt.Sprintf("%s\t%s\t%s", item.Bank.Date.Format("2006-01-02"), item.Bank.Amount.Decimal(), item.Bank.Reference)
+			}
+			wallet := "\t\t"
+			if item.WalletID != "" {
+				wallet = fmt.Sprintf("%s\t%s\t%s %s", item.Time.Format("2006-01-02"), item.Amount.Decimal(), item.Kind, item.WalletID)
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", bank, wallet, item.Reason)
+		}
+	}
+	section("Matched", r.Matched)
+	section("Mismatched", r.Mismatched)
+	section("Unmatched bank entries", r.UnmatchedBank)
+	section("Unmatched wallet records", r.UnmatchedWallet)
+	return tw.Flush()
+}
+
+func (r *Reconciliation) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
@@ -0,0 +1,114 @@
+package wallet
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCamt053 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <Stmt>
+      <Ntry>
+        <Amt Ccy="TJS">150.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <BookgDt><Dt>2022-07-01</Dt></BookgDt>
+        <NtryDtls><TxDtls><Refs><EndToEndId>NOTPROVIDED</EndToEndId></Refs><RmtInf><Ustrd>top up</Ustrd></RmtInf></TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="TJS">20.50</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <BookgDt><DtTm>2022-07-02T09:00:00Z</DtTm></BookgDt>
+        <NtryRef>B-2</NtryRef>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+
+func TestReadCamt053(t *testing.T) {
+	entries, err := ReadCamt053(strings.NewReader(testCamt053))
+	if err != nil {
+		t.Errorf("ReadCamt053(): error = %v", err)
+		return
+	}
+	if len(entries) != 2 {
+		t.Errorf("ReadCamt053(): got %v", entries)
+		return
+	}
+	if entries[0].Amount != 15_000 || entries[0].Reference != "top up" || !entries[0].Date.Equal(time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("ReadCamt053(): first entry = %v", entries[0])
+	}
+	if entries[1].Amount != -2_050 || entries[1].Reference != "B-2" {
+		t.Errorf("ReadCamt053(): second entry = %v", entries[1])
+	}
+}
+
+func TestService_Reconcile(t *testing.T) {
+	s := newTestService()
+	now := time.Date(2022, 7, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	_, err := s.addAccountWithBalance("+992985570302", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	byReference, err := s.Pay(1, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	byAmount, err := s.Pay(1, 2_050, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	wrongAmount, err := s.Pay(1, 300, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	missing, err := s.Pay(1, 400, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	statement := "date,amount,reference,description\n" +
+		"2022-07-01,100.00,," + "\n" +
+		"2022-07-01,-10.00," + byReference.ID + ",\n" +
+		"2022-07-02,-20.50,B-2,\n" +
+		"2022-07-01,-3.50," + wrongAmount.ID + ",\n" +
+		"2022-07-01,-99.00,X-1,unknown\n"
+	entries, err := ReadBankCSV(strings.NewReader(statement))
+	if err != nil {
+		t.Errorf("ReadBankCSV(): error = %v", err)
+		return
+	}
+	result := s.Reconcile(entries, 24*time.Hour)
+
+	if len(result.Matched) != 3 {
+		t.Errorf("Reconcile(): matched = %v", result.Matched)
+	} else if result.Matched[0].WalletID != byReference.ID || result.Matched[0].Reason != "reference" || result.Matched[2].WalletID != byAmount.ID {
+		t.Errorf("Reconcile(): matched = %v", result.Matched)
+	}
+	if len(result.Mismatched) != 1 || result.Mismatched[0].WalletID != wrongAmount.ID {
+		t.Errorf("Reconcile(): mismatched = %v", result.Mismatched)
+	}
+	if len(result.UnmatchedBank) != 1 || result.UnmatchedBank[0].Bank.Reference != "X-1" {
+		t.Errorf("Reconcile(): unmatched bank = %v", result.UnmatchedBank)
+	}
+	if len(result.UnmatchedWallet) != 1 || result.UnmatchedWallet[0].WalletID != missing.ID {
+		t.Errorf("Reconcile(): unmatched wallet = %v", result.UnmatchedWallet)
+	}
+
+	// a reference outside the statement dates is a date mismatch
+	late := []BankEntry{{Date: now.AddDate(0, 0, 9), Amount: -400, Reference: missing.ID}}
+	result = s.Reconcile(late, 24*time.Hour)
+	if len(result.Mismatched) != 1 || result.Mismatched[0].WalletID != missing.ID || !strings.HasPrefix(result.Mismatched[0].Reason, "date") {
+		t.Errorf("Reconcile(): mismatched = %v", result.Mismatched)
+	}
+	if len(result.UnmatchedBank) != 0 || len(result.UnmatchedWallet) != 0 {
+		t.Errorf("Reconcile(): unmatched bank = %v, wallet = %v", result.UnmatchedBank, result.UnmatchedWallet)
+	}
+}
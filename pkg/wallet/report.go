@@ -0,0 +1,236 @@
+package wallet
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+const monthLayout = "2006-01"
+
+// ReportQuery selects the payments a report covers. AccountID 0 means every
+// account, a zero From or To leaves that side of the period open.
+type ReportQuery struct {
+	AccountID int64
+	From      time.Time
+	To        time.Time
+	Top       int
+}
+
+type CategoryStat struct {
+	Category types.PaymentCategory `json:"category"`
+	Count    int                   `json:"count"`
+	Total    types.Money           `json:"total"`
+	Average  types.Money           `json:"average"`
+	Share    float64               `json:"share"`
+}
+
+type StatusStat struct {
+	Status types.PaymentStatus `json:"status"`
+	Count  int                 `json:"count"`
+	Total  types.Money         `json:"total"`
+}
+
+type PeriodStat struct {
+	Period      string      `json:"period"`
+	Count       int         `json:"count"`
+	Total       types.Money `json:"total"`
+	Average     types.Money `json:"average"`
+	Change      float64     `json:"change"`
+	HasPrevious bool        `json:"hasPrevious"`
+}
+
+// SpendingReport totals only payments that were not rejected; Statuses
+// counts every payment in the period so failures remain visible.
+type SpendingReport struct {
+	AccountID     int64          `json:"accountId,omitempty"`
+	From          time.Time      `json:"from"`
+	To            time.Time      `json:"to"`
+	Count         int            `json:"count"`
+	Total         types.Money    `json:"total"`
+	AverageTicket types.Money    `json:"averageTicket"`
+	Categories    []CategoryStat `json:"categories"`
+	TopCategories []CategoryStat `json:"topCategories"`
+	Statuses      []StatusStat   `json:"statuses"`
+	Months        []PeriodStat   `json:"months"`
+}
+
+// matches leaves out the interest and clawbacks the wallet charges, they
+// aren't spending.
+func (q ReportQuery) matches(payment types.Payment) bool {
+	if chargeCategory(payment.Category) {
+		return false
+	}
+	if q.AccountID != 0 && payment.AccountID != q.AccountID {
+		return false
+	}
+	if !q.From.IsZero() && payment.Created.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !payment.Created.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+func (q ReportQuery) spending(payment types.Payment) bool {
+	return q.matches(payment) && payment.Status != types.PaymentStatusFail
+}
+
+func mapAverage(payment types.Payment) Average {
+	return Average{Sum: payment.Amount, Count: 1}
+}
+
+func (s *Service) SpendingReport(ctx context.Context, query ReportQuery, workers int) (*SpendingReport, error) {
+	if query.AccountID != 0 {
+		_, err := s.FindAccountByID(query.AccountID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	categories, err := Aggregate(ctx, s, Aggregation[types.PaymentCategory, Average, Average]{
+		Filter: query.spending,
+		Map:    mapAverage,
+		Key:    func(payment types.Payment) types.PaymentCategory { return payment.Category },
+		Reduce: mergeAverage,
+		Merge:  mergeAverage,
+	}, workers)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := Aggregate(ctx, s, Aggregation[types.PaymentStatus, Average, Average]{
+		Filter: query.matches,
+		Map:    mapAverage,
+		Key:    func(payment types.Payment) types.PaymentStatus { return payment.Status },
+		Reduce: mergeAverage,
+		Merge:  mergeAverage,
+	}, workers)
+	if err != nil {
+		return nil, err
+	}
+	months, err := Aggregate(ctx, s, Aggregation[string, Average, Average]{
+		Filter: query.spending,
+		Map:    mapAverage,
+		Key:    paymentMonth,
+		Reduce: mergeAverage,
+		Merge:  mergeAverage,
+	}, workers)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SpendingReport{AccountID: query.AccountID, From: query.From, To: query.To}
+	for _, group := range categories {
+		report.Count += group.Value.Count
+		report.Total, err = report.Total.Add(group.Value.Sum)
+		if err != nil {
+			return nil, err
+		}
+	}
+	report.AverageTicket = Average{Sum: report.Total, Count: report.Count}.Value()
+
+	report.Categories = make([]CategoryStat, 0, len(categories))
+	for _, group := range categories {
+		stat := CategoryStat{
+			Category: group.Key,
+			Count:    group.Value.Count,
+			Total:    group.Value.Sum,
+			Average:  group.Value.Value(),
+		}
+		if report.Total != 0 {
+			stat.Share = float64(stat.Total) / float64(report.Total)
+		}
+		report.Categories = append(report.Categories, stat)
+	}
+	top := append([]CategoryStat(nil), report.Categories...)
+	sort.SliceStable(top, func(i, j int) bool {
+		return top[i].Total > top[j].Total
+	})
+	if query.Top > 0 && len(top) > query.Top {
+		top = top[:query.Top]
+	}
+	report.TopCategories = top
+
+	report.Statuses = make([]StatusStat, 0, len(statuses))
+	for _, group := range statuses {
+		report.Statuses = append(report.Statuses, StatusStat{Status: group.Key, Count: group.Value.Count, Total: group.Value.Sum})
+	}
+
+	report.Months = make([]PeriodStat, 0, len(months))
+	for i, group := range months {
+		stat := PeriodStat{
+			Period:  group.Key,
+			Count:   group.Value.Count,
+			Total:   group.Value.Sum,
+			Average: group.Value.Value(),
+		}
+		if i > 0 && isPreviousMonth(months[i-1].Key, group.Key) && months[i-1].Value.Sum != 0 {
+			prev := months[i-1].Value.Sum
+			stat.Change = float64(stat.Total-prev) / float64(prev)
+			stat.HasPrevious = true
+		}
+		report.Months = append(report.Months, stat)
+	}
+	return report, nil
+}
+
+// paymentMonth is "" for payments imported from dumps that predate timestamps.
+func paymentMonth(payment types.Payment) string {
+	if payment.Created.IsZero() {
+		return ""
+	}
+	return payment.Created.UTC().Format(monthLayout)
+}
+
+func isPreviousMonth(prev string, month string) bool {
+	p, err := time.Parse(monthLayout, prev)
+	if err != nil {
+		return false
+	}
+	return p.AddDate(0, 1, 0).Format(monthLayout) == month
+}
+
+func (r *SpendingReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteCSV writes one row per breakdown entry: section, key, count, total,
+// average and the share (categories) or month-over-month change (months).
+func (r *SpendingReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	money := func(m types.Money) string { return strconv.FormatInt(int64(m), 10) }
+	ratio := func(f float64) string { return strconv.FormatFloat(f, 'f', 4, 64) }
+
+	rows := [][]string{
+		{"section", "key", "count", "total", "average", "ratio"},
+		{"summary", "all", strconv.Itoa(r.Count), money(r.Total), money(r.AverageTicket), ""},
+	}
+	for _, stat := range r.Categories {
+		rows = append(rows, []string{"category", string(stat.Category), strconv.Itoa(stat.Count), money(stat.Total), money(stat.Average), ratio(stat.Share)})
+	}
+	for _, stat := range r.Statuses {
+		rows = append(rows, []string{"status", string(stat.Status), strconv.Itoa(stat.Count), money(stat.Total), "", ""})
+	}
+	for _, stat := range r.Months {
+		change := ""
+		if stat.HasPrevious {
+			change = ratio(stat.Change)
+		}
+		rows = append(rows, []string{"month", stat.Period, strconv.Itoa(stat.Count), money(stat.Total), money(stat.Average), change})
+	}
+
+	err := writer.WriteAll(rows)
+	if err != nil {
+		return err
+	}
+	return writer.Error()
+}
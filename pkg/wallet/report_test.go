@@ -0,0 +1,96 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestService_SpendingReport(t *testing.T) {
+	s := newTestService()
+	now := time.Date(2022, 1, 10, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	_, err := s.addAccountWithBalance("+992985570302", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.addAccountWithBalance("+992981111111", 1_000_00)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	pay := func(accountID int64, amount types.Money, category types.PaymentCategory) *types.Payment {
+		payment, err := s.Pay(accountID, amount, category)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return payment
+	}
+	pay(1, 100, "grocery")
+	pay(1, 300, "auto")
+	now = now.AddDate(0, 1, 0)
+	pay(1, 200, "grocery")
+	pay(1, 400, "grocery")
+	rejected := pay(1, 999, "auto")
+	pay(2, 50, "grocery")
+	err = s.Reject(rejected.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	report, err := s.SpendingReport(context.Background(), ReportQuery{AccountID: 1, Top: 1}, 2)
+	if err != nil {
+		t.Errorf("SpendingReport(): error = %v", err)
+		return
+	}
+	if report.Count != 4 || report.Total != 1000 || report.AverageTicket != 250 {
+		t.Errorf("SpendingReport(): wrong summary = %d/%d/%d", report.Count, report.Total, report.AverageTicket)
+	}
+	if len(report.TopCategories) != 1 || report.TopCategories[0].Category != "grocery" || report.TopCategories[0].Total != 700 {
+		t.Errorf("SpendingReport(): wrong top categories = %v", report.TopCategories)
+	}
+	if len(report.Statuses) != 2 || report.Statuses[0].Status != types.PaymentStatusFail {
+		t.Errorf("SpendingReport(): wrong statuses = %v", report.Statuses)
+	}
+	if len(report.Months) != 2 || !report.Months[1].HasPrevious || report.Months[1].Change != 0.5 {
+		t.Errorf("SpendingReport(): wrong months = %v", report.Months)
+	}
+
+	global, err := s.SpendingReport(context.Background(), ReportQuery{From: time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)}, 2)
+	if err != nil {
+		t.Errorf("SpendingReport(): error = %v", err)
+		return
+	}
+	if global.Total != 650 {
+		t.Errorf("SpendingReport(): global total = %v, want %v", global.Total, 650)
+	}
+
+	var buf bytes.Buffer
+	err = report.WriteCSV(&buf)
+	if err != nil {
+		t.Errorf("WriteCSV(): error = %v", err)
+		return
+	}
+	if !strings.Contains(buf.String(), "month,2022-02,2,600,300,0.5000") {
+		t.Errorf("WriteCSV(): missing month row in\n%s", buf.String())
+	}
+	buf.Reset()
+	err = report.WriteJSON(&buf)
+	if err != nil {
+		t.Errorf("WriteJSON(): error = %v", err)
+		return
+	}
+	var decoded SpendingReport
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	if err != nil || decoded.Total != report.Total {
+		t.Errorf("WriteJSON(): can't decode report, error = %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+package wallet
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidRewardRule = errors.New("invalid reward rule")
+var ErrNotEnoughCashback = errors.New("not enough cashback")
+
+const (
+	CashbackSource           = "cashback"
+	CashbackClawbackCategory = types.PaymentCategory("cashback_clawback")
+)
+
+type RewardPeriod string
+
+const (
+	RewardPeriodNone  RewardPeriod = ""
+	RewardPeriodDay   RewardPeriod = "day"
+	RewardPeriodMonth RewardPeriod = "month"
+)
+
+// RewardRule gives Percent basis points of matching payments as cashback.
+// An empty Category matches every category. From and To bound the campaign,
+// zero values leave that side open, and Cap limits the cashback one account
+// earns from the rule per Period.
+type RewardRule struct {
+	Name     string
+	Category types.PaymentCategory
+	Percent  int64
+	Cap      types.Money
+	Period   RewardPeriod
+	From     time.Time
+	To       time.Time
+}
+
+func (r RewardRule) active(at time.Time) bool {
+	if !r.From.IsZero() && at.Before(r.From) {
+		return false
+	}
+	if !r.To.IsZero() && !at.Before(r.To) {
+		return false
+	}
+	return true
+}
+
+func (r RewardRule) periodStart(at time.Time) time.Time {
+	at = at.UTC()
+	switch r.Period {
+	case RewardPeriodDay:
+		return time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	case RewardPeriodMonth:
+		return time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Time{}
+}
+
+func (s *Service) SetRewardRules(rules []RewardRule) (err error) {
+	defer func() {
+		s.record("SetRewardRules", auditArgs("rules", rules), "", err)
+	}()
+
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		switch {
+		case rule.Name == "" || names[rule.Name]:
+			return ErrInvalidRewardRule
+		case rule.Percent <= 0 || rule.Cap < 0:
+			return ErrInvalidRewardRule
+		case rule.Period != RewardPeriodNone && rule.Period != RewardPeriodDay && rule.Period != RewardPeriodMonth:
+			return ErrInvalidRewardRule
+		}
+		names[rule.Name] = true
+	}
+
+	s.rewardRules = append([]RewardRule(nil), rules...)
+	return nil
+}
+
+// earned sums the cashback the account kept from the rule since from.
+func (s *Service) earned(accountID int64, rule string, from time.Time) types.Money {
+	total := types.Money(0)
+	for _, reward := range s.rewards {
+		if reward.AccountID == accountID && reward.Rule == rule && reward.ClawedBack.IsZero() && !reward.Created.Before(from) {
+			total += reward.Amount
+		}
+	}
+	return total
+}
+
+// rewardsFor works out the cashback a payment earns before any money moves,
+// so an amount that overflows fails the payment instead of half paying it.
+func (s *Service) rewardsFor(account *types.Account, payment *types.Payment) ([]*types.Reward, error) {
+	if payment.Category == TransferCategory {
+		return nil, nil
+	}
+	var rewards []*types.Reward
+	for _, rule := range s.rewardRules {
+		if rule.Category != "" && rule.Category != payment.Category {
+			continue
+		}
+		if !rule.active(payment.Created) {
+			continue
+		}
+
+		amount, err := payment.Amount.Mul(rule.Percent)
+		if err != nil {
+			return nil, err
+		}
+		amount /= basisPoints
+		if rule.Cap > 0 {
+			left := rule.Cap - s.earned(account.ID, rule.Name, rule.periodStart(payment.Created))
+			if amount > left {
+				amount = left
+			}
+		}
+		if amount <= 0 {
+			continue
+		}
+		rewards = append(rewards, &types.Reward{
+			ID:        uuid.New().String(),
+			PaymentID: payment.ID,
+			AccountID: account.ID,
+			Rule:      rule.Name,
+			Amount:    amount,
+			Created:   payment.Created,
+		})
+	}
+	return rewards, nil
+}
+
+func (s *Service) reward(account *types.Account, rewards []*types.Reward) {
+	for _, reward := range rewards {
+		account.Cashback += reward.Amount
+		s.rewards = append(s.rewards, reward)
+	}
+}
+
+// clawback takes back the rewards of a rejected payment. Cashback that was
+// already redeemed is charged to the main balance.
+func (s *Service) clawback(account *types.Account, payment *types.Payment) error {
+	for _, reward := range s.rewards {
+		if reward.PaymentID != payment.ID || !reward.ClawedBack.IsZero() {
+			continue
+		}
+		fromCashback := reward.Amount
+		if fromCashback > account.Cashback {
+			fromCashback = account.Cashback
+		}
+		if fromCashback < 0 {
+			fromCashback = 0
+		}
+		if rest := reward.Amount - fromCashback; rest > 0 {
+			_, err := s.charge(account, rest, CashbackClawbackCategory)
+			if err != nil {
+				return err
+			}
+		}
+		account.Cashback -= fromCashback
+		reward.ClawedBack = s.clock().UTC()
+	}
+	return nil
+}
+
+func (s *Service) RedeemCashback(accountID int64, amount types.Money) (deposit *types.Deposit, err error) {
+	defer func() {
+		s.record("RedeemCashback", auditArgs("account", accountID, "amount", amount), "", err)
+	}()
+
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account.Cashback < amount {
+		return nil, ErrNotEnoughCashback
+	}
+
+	account.Cashback -= amount
+	return s.deposit(accountID, amount, CashbackSource)
+}
+
+func (s *Service) AccountRewards(accountID int64) ([]types.Reward, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	var rewards []types.Reward
+	for _, reward := range s.rewards {
+		if reward.AccountID == accountID {
+			rewards = append(rewards, *reward)
+		}
+	}
+	return rewards, nil
+}
+
+func rewardRows(rewards []*types.Reward) [][]string {
+	rows := make([][]string, len(rewards))
+	for i, reward := range rewards {
+		rows[i] = []string{
+			reward.ID,
+			reward.PaymentID,
+			strconv.FormatInt(reward.AccountID, 10),
+			reward.Rule,
+			strconv.FormatInt(int64(reward.Amount), 10),
+			formatTime(reward.Created),
+			formatTime(reward.ClawedBack),
+		}
+	}
+	return rows
+}
+
+func parseRewards(rows [][]string) []*types.Reward {
+	rewards := make([]*types.Reward, 0, len(rows))
+	for _, row := range rows {
+		accID, _ := strconv.ParseInt(dumpField(row, 2), 10, 64)
+		amount, _ := strconv.ParseInt(dumpField(row, 4), 10, 64)
+		rewards = append(rewards, &types.Reward{
+			ID:         row[0],
+			PaymentID:  dumpField(row, 1),
+			AccountID:  accID,
+			Rule:       dumpField(row, 3),
+			Amount:     types.Money(amount),
+			Created:    parseTime(dumpField(row, 5)),
+			ClawedBack: parseTime(dumpField(row, 6)),
+		})
+	}
+	return rewards
+}
@@ -0,0 +1,125 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestService_Pay_cashback(t *testing.T) {
+	s := newTestService()
+	now := time.Date(2022, 3, 10, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	account, err := s.addAccountWithBalance("+992985570302", 100_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.SetRewardRules([]RewardRule{
+		{Name: "grocery5", Category: "grocery", Percent: 500, Cap: 300, Period: RewardPeriodDay},
+		{Name: "spring", Percent: 100, From: time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	if err != nil {
+		t.Errorf("SetRewardRules(): error = %v", err)
+		return
+	}
+
+	_, err = s.Pay(1, 4_000, "grocery")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(1, 4_000, "grocery")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// grocery5 gives 200 and then only 100 left under the daily cap, spring gives 40 twice
+	if account.Cashback != 200+100+40+40 {
+		t.Errorf("Pay(): cashback = %d, want %d", account.Cashback, 380)
+	}
+
+	now = now.AddDate(0, 1, 0)
+	payment, err := s.Pay(1, 1_000, "grocery")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Cashback != 380+50 {
+		t.Errorf("Pay(): cashback after campaign = %d, want %d", account.Cashback, 430)
+	}
+
+	_, err = s.RedeemCashback(1, 400)
+	if err != nil {
+		t.Errorf("RedeemCashback(): error = %v", err)
+		return
+	}
+	_, err = s.RedeemCashback(1, 400)
+	if err != ErrNotEnoughCashback {
+		t.Errorf("RedeemCashback(): must return ErrNotEnoughCashback, returned = %v", err)
+	}
+	if account.Balance != 100_000-9_000+400 || account.Cashback != 30 {
+		t.Errorf("RedeemCashback(): account = %v", account)
+	}
+
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Errorf("Reject(): error = %v", err)
+		return
+	}
+	// 30 comes from cashback, the other 20 was already redeemed
+	if account.Cashback != 0 || account.Balance != 100_000-8_000+400-20 {
+		t.Errorf("Reject(): account = %v", account)
+	}
+	if mismatches := s.VerifyBalances(); len(mismatches) != 0 {
+		t.Errorf("VerifyBalances(): got mismatches = %v", mismatches)
+	}
+}
+
+func TestService_Pay_cashbackHeld(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992985570302", 100_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.SetRewardRules([]RewardRule{{Name: "all", Percent: 100}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetRiskChecks(func(request RiskRequest) RiskVerdict {
+		return RiskVerdict{Outcome: types.RiskReview, Rule: "review"}
+	})
+
+	declined, err := s.Pay(1, 10_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	held, err := s.Pay(1, 20_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if rewards, _ := s.AccountRewards(1); account.Cashback != 0 || len(rewards) != 0 {
+		t.Errorf("Pay(): held payments earned cashback = %d, rewards = %v", account.Cashback, rewards)
+	}
+	err = s.DeclinePayment(declined.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.ApprovePayment(held.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if rewards, _ := s.AccountRewards(1); account.Cashback != 200 || len(rewards) != 1 || rewards[0].PaymentID != held.ID {
+		t.Errorf("ApprovePayment(): cashback = %d, rewards = %v", account.Cashback, rewards)
+	}
+	if payments := s.payments; len(payments) != 2 {
+		t.Errorf("DeclinePayment(): no clawback charge expected, payments = %d", len(payments))
+	}
+}
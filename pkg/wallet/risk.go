@@ -0,0 +1,369 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrPaymentDenied = errors.New("payment denied by risk check")
+var ErrNotUnderReview = errors.New("payment is not under review")
+
+// RiskRequest describes a payment about to be made. Payments and Decisions
+// hold the account's earlier payments and risk decisions, oldest first.
+type RiskRequest struct {
+	Account   types.Account
+	Amount    types.Money
+	Category  types.PaymentCategory
+	RepeatOf  string
+	Time      time.Time
+	Payments  []types.Payment
+	Decisions []types.RiskDecision
+}
+
+type RiskVerdict struct {
+	Outcome types.RiskOutcome
+	Rule    string
+	Reason  string
+}
+
+// RiskCheck inspects a payment before it is made. A zero verdict allows it.
+type RiskCheck func(request RiskRequest) RiskVerdict
+
+// SetRiskChecks replaces the checks run by Pay and Repeat. The strictest
+// verdict wins: denied payments are not made, payments sent to review are
+// made with the REVIEW status and wait in ReviewQueue.
+func (s *Service) SetRiskChecks(checks ...RiskCheck) {
+	defer func() {
+		s.record("SetRiskChecks", auditArgs("checks", len(checks)), "", nil)
+	}()
+
+	s.riskChecks = append([]RiskCheck(nil), checks...)
+}
+
+func severity(outcome types.RiskOutcome) int {
+	switch outcome {
+	case types.RiskDeny:
+		return 2
+	case types.RiskReview:
+		return 1
+	}
+	return 0
+}
+
+// screen runs the risk checks and returns their decision for the caller to
+// record once the outcome is known. It returns nil when no checks are
+// configured.
+func (s *Service) screen(account *types.Account, amount types.Money, category types.PaymentCategory, repeatOf string) *types.RiskDecision {
+	if len(s.riskChecks) == 0 {
+		return nil
+	}
+
+	request := RiskRequest{
+		Account:  *account,
+		Amount:   amount,
+		Category: category,
+		RepeatOf: repeatOf,
+		Time:     s.clock().UTC(),
+	}
+	for _, payment := range s.payments {
+		if payment.AccountID == account.ID {
+			request.Payments = append(request.Payments, *payment)
+		}
+	}
+	for _, decision := range s.riskDecisions {
+		if decision.AccountID == account.ID {
+			request.Decisions = append(request.Decisions, *decision)
+		}
+	}
+
+	verdict := RiskVerdict{Outcome: types.RiskAllow}
+	for _, check := range s.riskChecks {
+		result := check(request)
+		if severity(result.Outcome) > severity(verdict.Outcome) {
+			verdict = result
+		}
+	}
+
+	decision := &types.RiskDecision{
+		ID:        uuid.New().String(),
+		AccountID: account.ID,
+		Amount:    amount,
+		Category:  category,
+		RepeatOf:  repeatOf,
+		Outcome:   verdict.Outcome,
+		Rule:      verdict.Rule,
+		Reason:    verdict.Reason,
+		Created:   request.Time,
+	}
+	return decision
+}
+
+func (s *Service) screenedPay(accountID int64, amount types.Money, category types.PaymentCategory, repeatOf string) (*types.Payment, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	decision := s.screen(account, amount, category, repeatOf)
+	if decision != nil && decision.Outcome == types.RiskDeny {
+		s.riskDecisions = append(s.riskDecisions, decision)
+		return nil, ErrPaymentDenied
+	}
+	// a payment that fails isn't made, so there's no decision to keep
+	payment, rewards, err := s.pay(accountID, amount, category)
+	if err != nil {
+		return nil, err
+	}
+	if decision != nil {
+		decision.PaymentID = payment.ID
+		s.riskDecisions = append(s.riskDecisions, decision)
+		if decision.Outcome == types.RiskReview {
+			// held payments earn their cashback on approval
+			payment.Status = types.PaymentStatusReview
+			return payment, nil
+		}
+	}
+	s.reward(account, rewards)
+	return payment, nil
+}
+
+// ReviewQueue returns the payments held for review, oldest first.
+func (s *Service) ReviewQueue() []types.Payment {
+	var queue []types.Payment
+	for _, payment := range s.payments {
+		if payment.Status == types.PaymentStatusReview {
+			queue = append(queue, *payment)
+		}
+	}
+	sort.SliceStable(queue, func(i, j int) bool {
+		return queue[i].Created.Before(queue[j].Created)
+	})
+	return queue
+}
+
+func (s *Service) paymentDecision(paymentID string) *types.RiskDecision {
+	for _, decision := range s.riskDecisions {
+		if decision.PaymentID == paymentID {
+			return decision
+		}
+	}
+	return nil
+}
+
+func (s *Service) reviewed(paymentID string) (*types.Payment, error) {
+	payment, err := s.FindPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != types.PaymentStatusReview {
+		return nil, ErrNotUnderReview
+	}
+	return payment, nil
+}
+
+func (s *Service) resolve(paymentID string, resolution types.RiskOutcome) {
+	if decision := s.paymentDecision(paymentID); decision != nil {
+		decision.Resolution = resolution
+		decision.Reviewer = s.actor
+		decision.Reviewed = s.clock().UTC()
+	}
+}
+
+// ApprovePayment releases a held payment, a held transfer reaches its
+// recipient and the payment earns its cashback.
+func (s *Service) ApprovePayment(paymentID string) (err error) {
+	defer func() {
+		s.record("ApprovePayment", auditArgs("payment", paymentID), "", err)
+	}()
+
+	payment, err := s.reviewed(paymentID)
+	if err != nil {
+		return err
+	}
+	account, err := s.FindAccountByID(payment.AccountID)
+	if err != nil {
+		return err
+	}
+	rewards, err := s.rewardsFor(account, payment)
+	if err != nil {
+		return err
+	}
+	// a transfer that can't be credited stays held
+	if payment.RecipientID != 0 {
+		err = s.completeTransfer(payment)
+		if err != nil {
+			return err
+		}
+	} else {
+		payment.Status = types.PaymentStatusInProgress
+	}
+	s.reward(account, rewards)
+	s.resolve(paymentID, types.RiskAllow)
+	return nil
+}
+
+// DeclinePayment rejects a held payment and returns the money.
+func (s *Service) DeclinePayment(paymentID string) (err error) {
+	defer func() {
+		s.record("DeclinePayment", auditArgs("payment", paymentID), "", err)
+	}()
+
+	_, err = s.reviewed(paymentID)
+	if err != nil {
+		return err
+	}
+	err = s.reject(paymentID)
+	if err != nil {
+		return err
+	}
+	s.resolve(paymentID, types.RiskDeny)
+	return nil
+}
+
+func (s *Service) RiskDecisions() []types.RiskDecision {
+	decisions := make([]types.RiskDecision, len(s.riskDecisions))
+	for i, decision := range s.riskDecisions {
+		decisions[i] = *decision
+	}
+	return decisions
+}
+
+// VelocityRule flags an account making max or more payments within window.
+func VelocityRule(max int, window time.Duration, outcome types.RiskOutcome) RiskCheck {
+	return func(request RiskRequest) RiskVerdict {
+		count := 0
+		for _, payment := range request.Payments {
+			if payment.Status != types.PaymentStatusFail && request.Time.Sub(payment.Created) < window {
+				count++
+			}
+		}
+		if count < max {
+			return RiskVerdict{}
+		}
+		return RiskVerdict{
+			Outcome: outcome,
+			Rule:    "velocity",
+			Reason:  fmt.Sprintf("%d payments in %s", count, window),
+		}
+	}
+}
+
+// UnusualAmountRule flags payments above factor times the account's average
+// payment. Accounts with fewer than minHistory payments are not checked.
+func UnusualAmountRule(factor int64, minHistory int, outcome types.RiskOutcome) RiskCheck {
+	return func(request RiskRequest) RiskVerdict {
+		total, count := types.Money(0), 0
+		for _, payment := range request.Payments {
+			if payment.Status != types.PaymentStatusFail {
+				total += payment.Amount
+				count++
+			}
+		}
+		if count == 0 || count < minHistory {
+			return RiskVerdict{}
+		}
+		average := total / types.Money(count)
+		if request.Amount <= average*types.Money(factor) {
+			return RiskVerdict{}
+		}
+		return RiskVerdict{
+			Outcome: outcome,
+			Rule:    "unusual_amount",
+			Reason:  fmt.Sprintf("amount %d is over %d times the average %d", request.Amount, factor, average),
+		}
+	}
+}
+
+// NewAccountRule flags payments over limit from accounts younger than age.
+// Accounts without a registration time are treated as old.
+func NewAccountRule(age time.Duration, limit types.Money, outcome types.RiskOutcome) RiskCheck {
+	return func(request RiskRequest) RiskVerdict {
+		created := request.Account.Created
+		if created.IsZero() || request.Time.Sub(created) >= age || request.Amount <= limit {
+			return RiskVerdict{}
+		}
+		return RiskVerdict{
+			Outcome: outcome,
+			Rule:    "new_account",
+			Reason:  fmt.Sprintf("amount %d from an account registered %s ago", request.Amount, request.Time.Sub(created)),
+		}
+	}
+}
+
+// RepeatRule flags the max-th Repeat of the same payment within window.
+func RepeatRule(max int, window time.Duration, outcome types.RiskOutcome) RiskCheck {
+	return func(request RiskRequest) RiskVerdict {
+		if request.RepeatOf == "" {
+			return RiskVerdict{}
+		}
+		count := 0
+		for _, decision := range request.Decisions {
+			if decision.RepeatOf == request.RepeatOf && decision.Outcome != types.RiskDeny && request.Time.Sub(decision.Created) < window {
+				count++
+			}
+		}
+		if count+1 < max {
+			return RiskVerdict{}
+		}
+		return RiskVerdict{
+			Outcome: outcome,
+			Rule:    "repeat",
+			Reason:  fmt.Sprintf("payment %s repeated %d times in %s", request.RepeatOf, count+1, window),
+		}
+	}
+}
+
+func riskDecisionRows(decisions []*types.RiskDecision) [][]string {
+	rows := make([][]string, len(decisions))
+	for i, decision := range decisions {
+		rows[i] = []string{
+			decision.ID,
+			decision.PaymentID,
+			strconv.FormatInt(decision.AccountID, 10),
+			strconv.FormatInt(int64(decision.Amount), 10),
+			string(decision.Category),
+			decision.RepeatOf,
+			string(decision.Outcome),
+			decision.Rule,
+			decision.Reason,
+			formatTime(decision.Created),
+			string(decision.Resolution),
+			decision.Reviewer,
+			formatTime(decision.Reviewed),
+		}
+	}
+	return rows
+}
+
+func parseRiskDecisions(rows [][]string) []*types.RiskDecision {
+	decisions := make([]*types.RiskDecision, 0, len(rows))
+	for _, row := range rows {
+		accID, _ := strconv.ParseInt(dumpField(row, 2), 10, 64)
+		amount, _ := strconv.ParseInt(dumpField(row, 3), 10, 64)
+		decisions = append(decisions, &types.RiskDecision{
+			ID:         row[0],
+			PaymentID:  dumpField(row, 1),
+			AccountID:  accID,
+			Amount:     types.Money(amount),
+			Category:   types.PaymentCategory(dumpField(row, 4)),
+			RepeatOf:   dumpField(row, 5),
+			Outcome:    types.RiskOutcome(dumpField(row, 6)),
+			Rule:       dumpField(row, 7),
+			Reason:     dumpField(row, 8),
+			Created:    parseTime(dumpField(row, 9)),
+			Resolution: types.RiskOutcome(dumpField(row, 10)),
+			Reviewer:   dumpField(row, 11),
+			Reviewed:   parseTime(dumpField(row, 12)),
+		})
+	}
+	return decisions
+}
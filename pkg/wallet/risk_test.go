@@ -0,0 +1,210 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestService_Pay_riskChecks(t *testing.T) {
+	s := newTestService()
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	account, err := s.addAccountWithBalance("+992985570302", 100_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetRiskChecks(
+		VelocityRule(3, time.Minute, types.RiskDeny),
+		NewAccountRule(24*time.Hour, 10_000, types.RiskReview),
+	)
+
+	held, err := s.Pay(1, 20_000, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	if held.Status != types.PaymentStatusReview || account.Balance != 80_000 {
+		t.Errorf("Pay(): payment = %v, balance = %d", held, account.Balance)
+	}
+	for i := 0; i < 2; i++ {
+		_, err = s.Pay(1, 100, "auto")
+		if err != nil {
+			t.Errorf("Pay(): error = %v", err)
+			return
+		}
+	}
+	_, err = s.Pay(1, 100, "auto")
+	if err != ErrPaymentDenied {
+		t.Errorf("Pay(): must return ErrPaymentDenied, returned = %v", err)
+	}
+	now = now.Add(time.Minute)
+	_, err = s.Pay(1, 100, "auto")
+	if err != nil {
+		t.Errorf("Pay(): must be allowed after the window, error = %v", err)
+	}
+
+	queue := s.ReviewQueue()
+	if len(queue) != 1 || queue[0].ID != held.ID {
+		t.Errorf("ReviewQueue(): got %v", queue)
+		return
+	}
+	s.SetActor("reviewer")
+	err = s.DeclinePayment(held.ID)
+	if err != nil {
+		t.Errorf("DeclinePayment(): error = %v", err)
+		return
+	}
+	if held.Status != types.PaymentStatusFail || account.Balance != 100_000-300 {
+		t.Errorf("DeclinePayment(): payment = %v, balance = %d", held, account.Balance)
+	}
+	err = s.ApprovePayment(held.ID)
+	if err != ErrNotUnderReview {
+		t.Errorf("ApprovePayment(): must return ErrNotUnderReview, returned = %v", err)
+	}
+
+	decisions := s.RiskDecisions()
+	if len(decisions) != 5 {
+		t.Errorf("RiskDecisions(): got %d decisions, want 5", len(decisions))
+		return
+	}
+	if decisions[0].Rule != "new_account" || decisions[0].Resolution != types.RiskDeny || decisions[0].Reviewer != "reviewer" {
+		t.Errorf("RiskDecisions(): first decision = %v", decisions[0])
+	}
+	if decisions[3].Outcome != types.RiskDeny || decisions[3].Rule != "velocity" || decisions[3].PaymentID != "" {
+		t.Errorf("RiskDecisions(): denied decision = %v", decisions[3])
+	}
+}
+
+func TestService_Pay_riskChecksFailedPayment(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992985570302", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetRiskChecks(func(RiskRequest) RiskVerdict {
+		return RiskVerdict{Outcome: types.RiskReview, Rule: "always"}
+	})
+
+	_, err = s.Pay(account.ID, 2_000, "auto")
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Pay(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+	if decisions := s.RiskDecisions(); len(decisions) != 0 {
+		t.Errorf("RiskDecisions(): a payment that wasn't made must leave no decision, got %v", decisions)
+	}
+
+	payment, err := s.Pay(account.ID, 500, "auto")
+	if err != nil {
+		t.Errorf("Pay(): error = %v", err)
+		return
+	}
+	decisions := s.RiskDecisions()
+	if len(decisions) != 1 || decisions[0].PaymentID != payment.ID {
+		t.Errorf("RiskDecisions(): got %v", decisions)
+	}
+}
+
+func TestService_Repeat_riskChecks(t *testing.T) {
+	s := newTestService()
+	_, err := s.addAccountWithBalance("+992985570302", 100_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	payment, err := s.Pay(1, 1_000, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetRiskChecks(
+		RepeatRule(3, time.Hour, types.RiskReview),
+		UnusualAmountRule(5, 1, types.RiskDeny),
+	)
+
+	for i := 0; i < 2; i++ {
+		repeated, err := s.Repeat(payment.ID)
+		if err != nil || repeated.Status != types.PaymentStatusInProgress {
+			t.Errorf("Repeat(): payment = %v, error = %v", repeated, err)
+			return
+		}
+	}
+	repeated, err := s.Repeat(payment.ID)
+	if err != nil || repeated.Status != types.PaymentStatusReview {
+		t.Errorf("Repeat(): must hold the third repeat, payment = %v, error = %v", repeated, err)
+		return
+	}
+	err = s.ApprovePayment(repeated.ID)
+	if err != nil || repeated.Status != types.PaymentStatusInProgress || len(s.ReviewQueue()) != 0 {
+		t.Errorf("ApprovePayment(): payment = %v, error = %v", repeated, err)
+	}
+
+	_, err = s.Pay(1, 5_001, "auto")
+	if err != ErrPaymentDenied {
+		t.Errorf("Pay(): must deny unusual amount, returned = %v", err)
+	}
+}
+
+func TestService_PayToPhone_riskChecks(t *testing.T) {
+	s := newTestService()
+	payer, err := s.addAccountWithBalance("+992985570302", 100_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	recipient, err := s.RegisterAccount("+992900000000")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetRiskChecks(func(request RiskRequest) RiskVerdict {
+		if request.Category == TransferCategory {
+			return RiskVerdict{Outcome: types.RiskReview, Rule: "p2p"}
+		}
+		return RiskVerdict{Outcome: types.RiskDeny, Rule: "merchant"}
+	})
+
+	held, err := s.PayToPhone(1, recipient.Phone, 20_000)
+	if err != nil {
+		t.Errorf("PayToPhone(): error = %v", err)
+		return
+	}
+	if held.Status != types.PaymentStatusReview || payer.Balance != 80_000 || recipient.Balance != 0 {
+		t.Errorf("PayToPhone(): payment = %v, balances = %d, %d", held, payer.Balance, recipient.Balance)
+	}
+	err = s.ApprovePayment(held.ID)
+	if err != nil {
+		t.Errorf("ApprovePayment(): error = %v", err)
+		return
+	}
+	if held.Status != types.PaymentStatusOk || recipient.Balance != 20_000 {
+		t.Errorf("ApprovePayment(): payment = %v, recipient balance = %d", held, recipient.Balance)
+	}
+
+	declined, err := s.PayToPhone(1, recipient.Phone, 5_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.DeclinePayment(declined.ID)
+	if err != nil {
+		t.Errorf("DeclinePayment(): error = %v", err)
+		return
+	}
+	if payer.Balance != 80_000 || recipient.Balance != 20_000 {
+		t.Errorf("DeclinePayment(): balances = %d, %d", payer.Balance, recipient.Balance)
+	}
+
+	_, err = s.CreateVoucher(types.Voucher{Code: "TEN", Kind: types.VoucherDiscount, Percent: 1_000, MaxUses: 1})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.PayWithVoucher(1, 2_000, "auto", "TEN")
+	if err != ErrPaymentDenied {
+		t.Errorf("PayWithVoucher(): must return ErrPaymentDenied, returned = %v", err)
+	}
+}
@@ -2,15 +2,18 @@ package wallet
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"math"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/adheeeem/wallet/pkg/types"
 	"github.com/google/uuid"
@@ -22,31 +25,92 @@ var ErrAccountNotFound = errors.New("account not found")
 var ErrNotEnoughBalance = errors.New("not enough balance")
 var ErrPaymentNotFound = errors.New("payment not found")
 var ErrFavoriteNotFound = errors.New("favorite not found")
+var ErrPaymentRejected = errors.New("payment already rejected")
 
 type Service struct {
 	nextAccountID int64
 	accounts      []*types.Account
 	payments      []*types.Payment
 	favorites     []*types.Favorite
+	deposits      []*types.Deposit
+	auditLog      []*types.AuditRecord
+	actor         string
+	now           func() time.Time
+
+	categories        []*types.Category
+	categoryMode      CategoryMode
+	unknownCategories map[types.PaymentCategory]int
+
+	defaultCountryCode string
+	phoneChanges       []*types.PhoneChange
+
+	feeRules         map[types.PaymentCategory]FeeRule
+	revenueAccountID int64
+	fees             []*types.Fee
+
+	rewardRules []RewardRule
+	rewards     []*types.Reward
+
+	vouchers           []*types.Voucher
+	voucherRedemptions []*types.VoucherRedemption
+
+	riskChecks    []RiskCheck
+	riskDecisions []*types.RiskDecision
+
+	blockedPhones     map[types.Phone]bool
+	blockedCategories map[types.PaymentCategory]bool
+	screeningMatches  []*types.ScreeningMatch
+
+	payouts []*types.Payout
+
+	// mu is held by the PayBatch workers while they pay. It is the only
+	// place the service runs payments concurrently, the service itself
+	// isn't safe for concurrent use.
+	mu      sync.Mutex
+	batches []*types.PaymentBatch
+
+	splits      []*types.PaymentSplit
+	splitShares []*types.SplitShare
 }
 
+// Progress is sent once per completed part and once more with Done set,
+// carrying the aggregate of every part in Total and Err if the sum was aborted.
 type Progress struct {
-	Part   int
-	Result types.Money
+	Part      int
+	Result    types.Money
+	Parts     int
+	Completed int
+	Percent   float64
+	Total     types.Money
+	Done      bool
+	Err       error
 }
 
-func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
+func (s *Service) RegisterAccount(phone types.Phone) (account *types.Account, err error) {
+	defer func() {
+		s.record("RegisterAccount", auditArgs("phone", phone), accountResult(account), err)
+	}()
+
+	normalized, err := NormalizePhone(phone, s.countryCode())
+	if err != nil {
+		return nil, err
+	}
+	if s.blockedPhones[normalized] {
+		s.screeningMatch(0, normalized, "", "RegisterAccount")
+		return nil, ErrBlockedPhone
+	}
 	for _, account := range s.accounts {
-		if account.Phone == phone {
+		if s.phoneKey(account.Phone) == normalized {
 			return nil, ErrPhoneRegistered
 		}
 	}
 
 	s.nextAccountID++
-	account := &types.Account{
+	account = &types.Account{
 		ID:      s.nextAccountID,
-		Phone:   phone,
+		Phone:   normalized,
 		Balance: 0,
+		Created: s.clock().UTC(),
 	}
 	s.accounts = append(s.accounts, account)
 
@@ -63,24 +127,41 @@ func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
 	return nil, ErrAccountNotFound
 }
 
-func (s *Service) Deposit(accountID int64, amount types.Money) error {
-	if amount <= 0 {
-		return ErrAmountMustBePositive
-	}
+func (s *Service) Deposit(accountID int64, amount types.Money) (err error) {
+	defer func() {
+		s.record("Deposit", auditArgs("account", accountID, "amount", amount), "", err)
+	}()
+
+	_, err = s.deposit(accountID, amount, "")
+	return err
+}
+
+func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (payment *types.Payment, err error) {
+	defer func() {
+		s.record("Pay", auditArgs("account", accountID, "amount", amount, "category", category), paymentResult(payment), err)
+	}()
 
-	account, err := s.FindAccountByID(accountID)
+	return s.checkedPay(accountID, amount, category, "")
+}
+
+// checkedPay validates the category and screens the payment. Audited
+// operations that make merchant payments use it instead of Pay, so only the
+// outer call is recorded.
+func (s *Service) checkedPay(accountID int64, amount types.Money, category types.PaymentCategory, repeatOf string) (*types.Payment, error) {
+	err := s.validateCategory(category)
 	if err != nil {
-		return ErrAccountNotFound
+		return nil, err
 	}
-
-	// зачисление средств пока не рассматриваем как платёж
-	account.Balance += amount
-	return nil
+	return s.screenedPay(accountID, amount, category, repeatOf)
 }
 
-func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+// pay debits the account without category validation or risk checks. Only
+// screenedPay calls it, so every debit path runs the risk rules. The cashback
+// the payment earns is returned for the caller to grant once the payment
+// isn't held for review.
+func (s *Service) pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, []*types.Reward, error) {
 	if amount <= 0 {
-		return nil, ErrAmountMustBePositive
+		return nil, nil, ErrAmountMustBePositive
 	}
 
 	var account *types.Account
@@ -91,14 +172,28 @@ func (s *Service) Pay(accountID int64, amount types.Money, category types.Paymen
 		}
 	}
 	if account == nil {
-		return nil, ErrAccountNotFound
+		return nil, nil, ErrAccountNotFound
+	}
+	err := s.screenPayment(account, category)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if account.Balance < amount {
-		return nil, ErrNotEnoughBalance
+	fee, err := s.feeFor(account, category, amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	total, err := amount.Add(fee)
+	if err != nil {
+		return nil, nil, err
+	}
+	if available(account) < total {
+		return nil, nil, ErrNotEnoughBalance
+	}
+	if _, err := account.Balance.Sub(total); err != nil {
+		return nil, nil, err
 	}
 
-	account.Balance -= amount
 	paymentID := uuid.New().String()
 	payment := &types.Payment{
 		ID:        paymentID,
@@ -106,9 +201,27 @@ func (s *Service) Pay(accountID int64, amount types.Money, category types.Paymen
 		Amount:    amount,
 		Category:  category,
 		Status:    types.PaymentStatusInProgress,
+		Created:   s.clock().UTC(),
+	}
+	rewards, err := s.rewardsFor(account, payment)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the fee goes first: it can fail on the revenue account, the debit
+	// can't fail once the total was checked
+	if fee > 0 {
+		err = s.chargeFee(account, payment, fee)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	err = s.debit(account, amount)
+	if err != nil {
+		return nil, nil, err
 	}
 	s.payments = append(s.payments, payment)
-	return payment, nil
+	return payment, rewards, nil
 }
 
 func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
@@ -121,37 +234,125 @@ func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
 	return nil, ErrPaymentNotFound
 }
 
-func (s *Service) Reject(paymentID string) error {
+// Reject refunds the payment. Shares of a split payment can only be
+// rejected together with RejectSplit.
+func (s *Service) Reject(paymentID string) (err error) {
+	defer func() {
+		s.record("Reject", auditArgs("payment", paymentID), "", err)
+	}()
+
+	if s.paymentShare(paymentID) != nil {
+		return ErrSplitShare
+	}
+	return s.reject(paymentID)
+}
+
+// reject refunds the payment without an audit record of its own, for
+// audited operations that reject payments. Rejecting a split share, e.g. on
+// a declined review or a bank rejection, rejects the whole split.
+func (s *Service) reject(paymentID string) error {
 	payment, err := s.FindPaymentByID(paymentID)
 	if err != nil {
 		return err
 	}
+	if share := s.paymentShare(paymentID); share != nil && payment.Status != types.PaymentStatusFail {
+		split, err := s.FindSplit(share.SplitID)
+		if err != nil {
+			return err
+		}
+		return s.rejectSplit(split)
+	}
+	return s.rejectPayment(payment)
+}
+
+func (s *Service) rejectPayment(payment *types.Payment) error {
+	if payment.Status == types.PaymentStatusFail {
+		return ErrPaymentRejected
+	}
+	if chargeCategory(payment.Category) {
+		return ErrChargeNotRejectable
+	}
+	if s.paidOut(payment.ID) {
+		return ErrPaymentPaidOut
+	}
 	account, err := s.FindAccountByID(payment.AccountID)
 	if err != nil {
 		return err
 	}
+	// the refund with its fee must fit the balance before anything is undone
+	_, feeRefund, err := s.feeRefund(payment, payment.Amount)
+	if err != nil {
+		return err
+	}
+	refund, err := payment.Amount.Add(feeRefund)
+	if err != nil {
+		return err
+	}
+	if _, err := account.Balance.Add(refund); err != nil {
+		return err
+	}
+	// a transfer held for review or one that couldn't be credited hasn't
+	// reached the recipient
+	if payment.RecipientID != 0 && payment.Status == types.PaymentStatusOk {
+		err = s.reverseTransfer(payment)
+		if err != nil {
+			return err
+		}
+	}
 
+	err = s.credit(account, payment.Amount)
+	if err != nil {
+		return err
+	}
 	payment.Status = types.PaymentStatusFail
-	account.Balance += payment.Amount
+	payment.Rejected = s.clock().UTC()
+	err = s.refundFee(payment, payment.Amount)
+	if err != nil {
+		return err
+	}
+	err = s.clawback(account, payment)
+	if err != nil {
+		return err
+	}
+	s.revertVoucher(payment)
 	return nil
 }
 
-func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
+func (s *Service) Repeat(paymentID string) (repeated *types.Payment, err error) {
+	defer func() {
+		s.record("Repeat", auditArgs("payment", paymentID), paymentResult(repeated), err)
+	}()
+
 	payment, err := s.FindPaymentByID(paymentID)
 	if err != nil {
 		return nil, err
 	}
+	if payment.RecipientID != 0 {
+		return s.transfer(payment.AccountID, payment.RecipientID, payment.Amount, payment.ID)
+	}
 
-	return s.Pay(payment.AccountID, payment.Amount, payment.Category)
+	return s.checkedPay(payment.AccountID, payment.Amount, payment.Category, payment.ID)
 }
 
-func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
+func (s *Service) FavoritePayment(paymentID string, name string) (favorite *types.Favorite, err error) {
+	defer func() {
+		result := ""
+		if favorite != nil {
+			result = "favorite=" + favorite.ID
+		}
+		s.record("FavoritePayment", auditArgs("payment", paymentID, "name", name), result, err)
+	}()
+
 	payment, err := s.FindPaymentByID(paymentID)
 	if err != nil {
 		return nil, err
 	}
+	err = s.validateCategory(payment.Category)
+	if err != nil {
+		return nil, err
+	}
 
-	favorite := &types.Favorite{
+	favorite = &types.Favorite{
 		ID:        uuid.New().String(),
 		AccountID: payment.AccountID,
 		Amount:    payment.Amount,
@@ -173,16 +374,16 @@ func (s *Service) FindFavoriteByID(favoriteID string) (*types.Favorite, error) {
 	return nil, ErrFavoriteNotFound
 }
 
-func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
+func (s *Service) PayFromFavorite(favoriteID string) (payment *types.Payment, err error) {
+	defer func() {
+		s.record("PayFromFavorite", auditArgs("favorite", favoriteID), paymentResult(payment), err)
+	}()
+
 	favorite, err := s.FindFavoriteByID(favoriteID)
 	if err != nil {
 		return nil, err
 	}
-	payment, err := s.Pay(favorite.AccountID, favorite.Amount, favorite.Category)
-	if err == nil {
-		return nil, err
-	}
-	return payment, nil
+	return s.checkedPay(favorite.AccountID, favorite.Amount, favorite.Category, "")
 }
 
 func (s *Service) ExportToFile(path string) error {
@@ -206,7 +407,11 @@ func (s *Service) ExportToFile(path string) error {
 	return nil
 }
 
-func (s *Service) ImportFromFile(path string) error {
+func (s *Service) ImportFromFile(path string) (err error) {
+	defer func() {
+		s.record("ImportFromFile", auditArgs("path", path), "", err)
+	}()
+
 	file, err := os.Open(path)
 	if err != nil {
 		log.Print(err)
@@ -260,10 +465,7 @@ func (s *Service) Export(dir string) error {
 			return err
 		}
 		for _, account := range s.accounts {
-			id := strconv.FormatInt(account.ID, 10)
-			phone := account.Phone
-			balance := strconv.FormatInt(int64(account.Balance), 10)
-			_, err = acc.Write([]byte(id + ";" + string(phone) + ";" + balance + "\n"))
+			_, err = acc.Write([]byte(formatAccount(account) + "\n"))
 			if err != nil {
 				log.Print(err)
 				return err
@@ -278,7 +480,7 @@ func (s *Service) Export(dir string) error {
 			return err
 		}
 		for _, payment := range s.payments {
-			_, err = pay.Write([]byte(payment.ID + ";" + strconv.Itoa(int(payment.Amount)) + ";" + string(payment.Category) + ";" + string(payment.Status) + ";" + strconv.Itoa(int(payment.AccountID)) + "\n"))
+			_, err = pay.Write([]byte(formatPayment(payment) + "\n"))
 			if err != nil {
 				log.Print(err)
 				return err
@@ -299,10 +501,96 @@ func (s *Service) Export(dir string) error {
 			}
 		}
 	}
+	// always written: Import takes a dump without it for one made before
+	// deposits were recorded
+	err := writeRecords(dir+"/deposits.dump", depositRows(s.deposits))
+	if err != nil {
+		return err
+	}
+	if len(s.phoneChanges) > 0 {
+		err := writeRecords(dir+"/phones.dump", phoneChangeRows(s.phoneChanges))
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.fees) > 0 {
+		err := writeRecords(dir+"/fees.dump", feeRows(s.fees))
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.rewards) > 0 {
+		err := writeRecords(dir+"/rewards.dump", rewardRows(s.rewards))
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.vouchers) > 0 {
+		err := writeRecords(dir+"/vouchers.dump", voucherRows(s.vouchers))
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.voucherRedemptions) > 0 {
+		err := writeRecords(dir+"/redemptions.dump", redemptionRows(s.voucherRedemptions))
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.riskDecisions) > 0 {
+		err := writeRecords(dir+"/risk.dump", riskDecisionRows(s.riskDecisions))
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.screeningMatches) > 0 {
+		err := writeRecords(dir+"/screening.dump", screeningRows(s.screeningMatches))
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.payouts) > 0 {
+		err := writeRecords(dir+"/payouts.dump", payoutRows(s.payouts))
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.batches) > 0 {
+		err := writeRecords(dir+"/batches.dump", batchRows(s.batches))
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.splits) > 0 {
+		err := writeRecords(dir+"/splits.dump", splitRows(s.splits))
+		if err != nil {
+			return err
+		}
+		err = writeRecords(dir+"/split_shares.dump", splitShareRows(s.splitShares))
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.categories) > 0 {
+		err := writeRecords(dir+"/categories.dump", categoryRows(s.categories))
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.auditLog) > 0 {
+		err := s.ExportAudit(dir + "/audit.dump")
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (s *Service) Import(dir string) error {
+func (s *Service) Import(dir string) (err error) {
+	defer func() {
+		s.record("Import", auditArgs("dir", dir), "", err)
+	}()
+
 	acc, err := os.Open(dir + "/accounts.dump")
 	if err != nil {
 		log.Print(err)
@@ -323,6 +611,28 @@ func (s *Service) Import(dir string) error {
 			log.Print(cerr)
 		}
 	}()
+	if _, serr := os.Stat(dir + "/audit.dump"); serr == nil {
+		// the dump's chain can't be joined to one already started
+		if len(s.auditLog) > 0 {
+			return ErrAuditLogStarted
+		}
+		records, err := ReadAuditFile(dir + "/audit.dump")
+		if err != nil {
+			return err
+		}
+		head, err := ReadAuditHeadFile(dir + "/audit.dump")
+		if err != nil {
+			return err
+		}
+		_, err = VerifyAuditHead(records, head)
+		if err != nil {
+			return err
+		}
+		for i := range records {
+			s.auditLog = append(s.auditLog, &records[i])
+		}
+	}
+	var imported []*types.Account
 	reader := bufio.NewReader(acc)
 	for {
 		line, err := reader.ReadString('\n')
@@ -334,16 +644,12 @@ func (s *Service) Import(dir string) error {
 			log.Print(err)
 			return err
 		}
-		data := strings.Split(line, ";")
-		id, _ := strconv.ParseInt(data[0], 10, 64)
-		balance, _ := strconv.Atoi(strings.Trim(data[2], "\n"))
-		phone := types.Phone(data[1])
-		account := &types.Account{
-			ID:      id,
-			Phone:   phone,
-			Balance: types.Money(balance),
+		account := parseAccount(line)
+		if account.ID > s.nextAccountID {
+			s.nextAccountID = account.ID
 		}
 		s.accounts = append(s.accounts, account)
+		imported = append(imported, account)
 	}
 	reader = bufio.NewReader(pay)
 	for {
@@ -356,17 +662,7 @@ func (s *Service) Import(dir string) error {
 			log.Print(err)
 			return err
 		}
-		data := strings.Split(line, ";")
-		amount, _ := strconv.Atoi(strings.Trim(data[1], "\n"))
-		accID, _ := strconv.ParseInt(data[4], 10, 64)
-		payment := &types.Payment{
-			ID:        data[0],
-			Amount:    types.Money(amount),
-			Category:  types.PaymentCategory(data[2]),
-			Status:    types.PaymentStatus(data[3]),
-			AccountID: accID,
-		}
-		s.payments = append(s.payments, payment)
+		s.payments = append(s.payments, parsePayment(line))
 	}
 	reader = bufio.NewReader(fav)
 	for {
@@ -391,6 +687,89 @@ func (s *Service) Import(dir string) error {
 		}
 		s.favorites = append(s.favorites, favorite)
 	}
+
+	phoneChanges, err := readRecords(dir + "/phones.dump")
+	if err != nil {
+		return err
+	}
+	s.phoneChanges = append(s.phoneChanges, parsePhoneChanges(phoneChanges)...)
+
+	_, serr := os.Stat(dir + "/deposits.dump")
+	preLedger := errors.Is(serr, fs.ErrNotExist)
+	deposits, err := readRecords(dir + "/deposits.dump")
+	if err != nil {
+		return err
+	}
+	s.deposits = append(s.deposits, parseDeposits(deposits)...)
+
+	fees, err := readRecords(dir + "/fees.dump")
+	if err != nil {
+		return err
+	}
+	s.fees = append(s.fees, parseFees(fees)...)
+
+	rewards, err := readRecords(dir + "/rewards.dump")
+	if err != nil {
+		return err
+	}
+	s.rewards = append(s.rewards, parseRewards(rewards)...)
+
+	vouchers, err := readRecords(dir + "/vouchers.dump")
+	if err != nil {
+		return err
+	}
+	s.vouchers = append(s.vouchers, parseVouchers(vouchers)...)
+
+	redemptions, err := readRecords(dir + "/redemptions.dump")
+	if err != nil {
+		return err
+	}
+	s.voucherRedemptions = append(s.voucherRedemptions, parseRedemptions(redemptions)...)
+
+	decisions, err := readRecords(dir + "/risk.dump")
+	if err != nil {
+		return err
+	}
+	s.riskDecisions = append(s.riskDecisions, parseRiskDecisions(decisions)...)
+
+	matches, err := readRecords(dir + "/screening.dump")
+	if err != nil {
+		return err
+	}
+	s.screeningMatches = append(s.screeningMatches, parseScreeningMatches(matches)...)
+
+	payouts, err := readRecords(dir + "/payouts.dump")
+	if err != nil {
+		return err
+	}
+	s.payouts = append(s.payouts, parsePayouts(payouts)...)
+
+	batches, err := readRecords(dir + "/batches.dump")
+	if err != nil {
+		return err
+	}
+	s.batches = append(s.batches, parseBatches(batches)...)
+
+	splits, err := readRecords(dir + "/splits.dump")
+	if err != nil {
+		return err
+	}
+	s.splits = append(s.splits, parseSplits(splits)...)
+	splitShares, err := readRecords(dir + "/split_shares.dump")
+	if err != nil {
+		return err
+	}
+	s.splitShares = append(s.splitShares, parseSplitShares(splitShares)...)
+
+	categories, err := readRecords(dir + "/categories.dump")
+	if err != nil {
+		return err
+	}
+	s.categories = append(s.categories, parseCategories(categories)...)
+
+	if preLedger {
+		return s.openingDeposits(imported)
+	}
 	return nil
 }
 
@@ -414,12 +793,7 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 			return err
 		}
 		for j := 0; j < len(payments); j++ {
-			id := payments[j].ID
-			amount := payments[j].Amount
-			category := payments[j].Category
-			status := payments[j].Status
-			accID := payments[j].AccountID
-			_, err = pay.Write([]byte(id + ";" + strconv.Itoa(int(amount)) + ";" + string(category) + ";" + string(status) + ";" + strconv.Itoa(int(accID)) + "\n"))
+			_, err = pay.Write([]byte(formatPayment(&payments[j]) + "\n"))
 			if err != nil {
 				log.Print(err)
 				return err
@@ -435,12 +809,7 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 			return err
 		}
 		for j := 0; j < records; j++ {
-			id := payments[ind].ID
-			amount := payments[ind].Amount
-			category := payments[ind].Category
-			status := payments[ind].Status
-			accID := payments[ind].AccountID
-			_, err = pay.Write([]byte(id + ";" + strconv.Itoa(int(amount)) + ";" + string(category) + ";" + string(status) + ";" + strconv.Itoa(int(accID)) + "\n"))
+			_, err = pay.Write([]byte(formatPayment(&payments[ind]) + "\n"))
 			if err != nil {
 				log.Print(err)
 				return err
@@ -454,129 +823,49 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 	return nil
 }
 
-func (s *Service) SumPayments(goroutines int) types.Money {
-	mu := sync.Mutex{}
-	sum := types.Money(0)
-	wg := sync.WaitGroup{}
-	for i := 0; i < len(s.payments); i += goroutines {
-		j := i
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if j+goroutines <= len(s.payments) {
-				val := types.Money(0)
-				for _, payment := range s.payments[j : j+goroutines] {
-					val += payment.Amount
-				}
-				mu.Lock()
-				defer mu.Unlock()
-				sum += val
-			} else {
-				val := types.Money(0)
-				for _, payment := range s.payments[j:] {
-					val += payment.Amount
-				}
-				mu.Lock()
-				defer mu.Unlock()
-				sum += val
-			}
-		}()
-	}
-	wg.Wait()
-
-	return sum
+// SumPayments sums goroutines payments per goroutine, one goroutine per
+// part, unlike SumPaymentsContext which takes the number of workers. It
+// returns types.ErrMoneyOverflow if the sum doesn't fit Money.
+func (s *Service) SumPayments(goroutines int) (types.Money, error) {
+	parts := chunks(len(s.payments), goroutines)
+	return sumPayments(context.Background(), s.payments, len(parts), parts)
 }
 
 func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payment, error) {
-	wg := sync.WaitGroup{}
-
-	mu := sync.Mutex{}
-	var answer []types.Payment
-	for i := 0; i < len(s.payments); i += goroutines {
-		j := i
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if j+goroutines <= len(s.payments) {
-				var pays []types.Payment
-				for _, payment := range s.payments[j : j+goroutines] {
-					if payment.AccountID == accountID {
-						pays = append(pays, *payment)
-					}
-				}
-				mu.Lock()
-				defer mu.Unlock()
-				answer = append(answer, pays...)
-			} else {
-				var pays []types.Payment
-				for _, payment := range s.payments[j:] {
-					if payment.AccountID == accountID {
-						pays = append(pays, *payment)
-					}
-				}
-				mu.Lock()
-				defer mu.Unlock()
-				answer = append(answer, pays...)
-			}
-		}()
-	}
-	wg.Wait()
-
-	return answer, nil
+	return s.FilterPaymentByFn(func(payment types.Payment) bool {
+		return payment.AccountID == accountID
+	}, goroutines)
 }
 
 func (s *Service) FilterPaymentByFn(filter func(payment types.Payment) bool, goroutines int) ([]types.Payment, error) {
 	wg := sync.WaitGroup{}
 
-	mu := sync.Mutex{}
-	var answer []types.Payment
-	for i := 0; i < len(s.payments); i += goroutines {
-		j := i
+	// each goroutine fills only its own slot, so results keep storage order
+	parts := chunks(len(s.payments), goroutines)
+	results := make([][]types.Payment, len(parts))
+	for _, part := range parts {
+		part := part
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			if j+goroutines <= len(s.payments) {
-				var pays []types.Payment
-				for _, payment := range s.payments[j : j+goroutines] {
-					if filter(*payment) {
-						pays = append(pays, *payment)
-					}
-				}
-				mu.Lock()
-				defer mu.Unlock()
-				answer = append(answer, pays...)
-			} else {
-				var pays []types.Payment
-				for _, payment := range s.payments[j:] {
-					if filter(*payment) {
-						pays = append(pays, *payment)
-					}
+			var pays []types.Payment
+			for _, payment := range s.payments[part.from:part.to] {
+				if filter(*payment) {
+					pays = append(pays, *payment)
 				}
-				mu.Lock()
-				defer mu.Unlock()
-				answer = append(answer, pays...)
 			}
+			results[part.index] = pays
 		}()
 	}
 	wg.Wait()
 
+	var answer []types.Payment
+	for _, pays := range results {
+		answer = append(answer, pays...)
+	}
 	return answer, nil
 }
 
 func (s *Service) SumPaymentsWithProgress() <-chan Progress {
-	parts := math.Ceil(float64(len(s.payments) / 100_000))
-	size := 100_000
-	ch := make(chan Progress)
-	for i := 0; i < int(parts); i++ {
-		j := i
-		go func(ch chan<- Progress, data []*types.Payment) {
-			defer close(ch)
-			sum := types.Money(0)
-			for _, v := range data {
-				sum += v.Amount
-			}
-			ch <- Progress{Part: j, Result: sum}
-		}(ch, s.payments[i*size:(i+1)*size])
-	}
-	return ch
+	return s.SumPaymentsWithProgressContext(context.Background(), 100_000)
 }
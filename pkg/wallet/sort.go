@@ -0,0 +1,73 @@
+package wallet
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+var ErrUnknownSortKey = errors.New("unknown sort key")
+
+type PaymentSortKey string
+
+const (
+	SortByStored   PaymentSortKey = ""
+	SortByAmount   PaymentSortKey = "amount"
+	SortByCategory PaymentSortKey = "category"
+	SortByStatus   PaymentSortKey = "status"
+	SortByAccount  PaymentSortKey = "account"
+	SortByTime     PaymentSortKey = "time"
+)
+
+func paymentLess(key PaymentSortKey) (func(a, b types.Payment) bool, error) {
+	switch key {
+	case SortByStored:
+		return nil, nil
+	case SortByAmount:
+		return func(a, b types.Payment) bool { return a.Amount < b.Amount }, nil
+	case SortByCategory:
+		return func(a, b types.Payment) bool { return a.Category < b.Category }, nil
+	case SortByStatus:
+		return func(a, b types.Payment) bool { return a.Status < b.Status }, nil
+	case SortByAccount:
+		return func(a, b types.Payment) bool { return a.AccountID < b.AccountID }, nil
+	case SortByTime:
+		return func(a, b types.Payment) bool { return a.Created.Before(b.Created) }, nil
+	}
+	return nil, ErrUnknownSortKey
+}
+
+// SortPayments orders payments by key in place. The sort is stable, so equal
+// keys keep the order they were stored in and repeated queries page the same way.
+func SortPayments(payments []types.Payment, key PaymentSortKey, desc bool) error {
+	less, err := paymentLess(key)
+	if err != nil {
+		return err
+	}
+	if less == nil {
+		return nil
+	}
+	sort.SliceStable(payments, func(i, j int) bool {
+		if desc {
+			return less(payments[j], payments[i])
+		}
+		return less(payments[i], payments[j])
+	})
+	return nil
+}
+
+func (s *Service) FilterPaymentByFnSorted(filter func(payment types.Payment) bool, goroutines int, key PaymentSortKey, desc bool) ([]types.Payment, error) {
+	if _, err := paymentLess(key); err != nil {
+		return nil, err
+	}
+	payments, err := s.FilterPaymentByFn(filter, goroutines)
+	if err != nil {
+		return nil, err
+	}
+	err = SortPayments(payments, key, desc)
+	if err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
@@ -0,0 +1,53 @@
+package wallet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestService_FilterPaymentByFn_storageOrder(t *testing.T) {
+	s := newServiceWithPayments(t, 1_000)
+	var want []types.Payment
+	for _, payment := range s.payments {
+		if payment.Amount > 5 {
+			want = append(want, *payment)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		got, err := s.FilterPaymentByFn(func(payment types.Payment) bool {
+			return payment.Amount > 5
+		}, 7)
+		if err != nil {
+			t.Errorf("FilterPaymentByFn(): error = %v", err)
+			return
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("FilterPaymentByFn(): results not in storage order")
+			return
+		}
+	}
+}
+
+func TestService_FilterPaymentByFnSorted(t *testing.T) {
+	s := newServiceWithPayments(t, 100)
+	got, err := s.FilterPaymentByFnSorted(func(payment types.Payment) bool {
+		return payment.AccountID == 1
+	}, 9, SortByAmount, true)
+	if err != nil {
+		t.Errorf("FilterPaymentByFnSorted(): error = %v", err)
+		return
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i-1].Amount < got[i].Amount {
+			t.Errorf("FilterPaymentByFnSorted(): not sorted by amount desc at %d", i)
+			return
+		}
+	}
+
+	_, err = s.FilterPaymentByFnSorted(func(payment types.Payment) bool { return true }, 9, "color", false)
+	if err != ErrUnknownSortKey {
+		t.Errorf("FilterPaymentByFnSorted(): must return ErrUnknownSortKey, returned = %v", err)
+	}
+}
@@ -0,0 +1,407 @@
+package wallet
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/adheeeem/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidSplit = errors.New("invalid split payment")
+var ErrSplitNotFound = errors.New("split payment not found")
+var ErrSplitClosed = errors.New("split payment is not pending")
+var ErrNotSplitParticipant = errors.New("account doesn't take part in the split payment")
+var ErrShareAnswered = errors.New("split payment share already answered")
+var ErrSplitShare = errors.New("payment is a split payment share, reject the split")
+
+type SplitMode string
+
+const (
+	// SplitImmediate pays every share at once, or none if any participant can't pay.
+	SplitImmediate SplitMode = "immediate"
+	// SplitRequest sends each participant a request to accept or decline.
+	SplitRequest SplitMode = "request"
+)
+
+// SplitPart is one participant's share of a split payment.
+type SplitPart struct {
+	AccountID int64
+	Amount    types.Money
+}
+
+// EqualParts splits the amount equally between the accounts. The dirams
+// that don't divide evenly go one each to the first accounts.
+func EqualParts(amount types.Money, accountIDs ...int64) []SplitPart {
+	if len(accountIDs) == 0 {
+		return nil
+	}
+	share := amount / types.Money(len(accountIDs))
+	rest := amount % types.Money(len(accountIDs))
+	parts := make([]SplitPart, len(accountIDs))
+	for i, accountID := range accountIDs {
+		parts[i] = SplitPart{AccountID: accountID, Amount: share}
+		if types.Money(i) < rest {
+			parts[i].Amount++
+		}
+	}
+	return parts
+}
+
+func validSplit(amount types.Money, parts []SplitPart) bool {
+	if amount <= 0 || len(parts) == 0 {
+		return false
+	}
+	seen := make(map[int64]bool, len(parts))
+	var sum types.Money
+	for _, part := range parts {
+		if part.Amount <= 0 || seen[part.AccountID] {
+			return false
+		}
+		seen[part.AccountID] = true
+		var err error
+		sum, err = sum.Add(part.Amount)
+		if err != nil {
+			return false
+		}
+	}
+	return sum == amount
+}
+
+// SplitPayment shares one payment between several accounts, each share
+// becoming a child payment of its own linked to the split. The parts must
+// add up to the amount, see EqualParts for equal shares.
+//
+// In SplitImmediate mode the split succeeds only if every participant can
+// pay: otherwise nothing is paid and the failing participant's error is
+// returned. In SplitRequest mode nothing is paid until each participant
+// calls AcceptSplit; one declined request fails the whole split.
+func (s *Service) SplitPayment(amount types.Money, category types.PaymentCategory, parts []SplitPart, mode SplitMode) (split *types.PaymentSplit, err error) {
+	defer func() {
+		result := ""
+		if split != nil {
+			result = "split=" + split.ID
+		}
+		s.record("SplitPayment", auditArgs("amount", amount, "category", category, "parts", len(parts), "mode", mode), result, err)
+	}()
+
+	if !validSplit(amount, parts) || (mode != SplitImmediate && mode != SplitRequest) {
+		return nil, ErrInvalidSplit
+	}
+	// counted once here in permissive mode, see splitPay
+	err = s.validateCategory(category)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		_, err = s.FindAccountByID(part.AccountID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var results []BatchResult
+	if mode == SplitImmediate {
+		results, err = s.paySplit(category, parts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now := s.clock().UTC()
+	split = &types.PaymentSplit{
+		ID:       uuid.New().String(),
+		Amount:   amount,
+		Category: category,
+		Mode:     string(mode),
+		Status:   types.SplitStatusPending,
+		Created:  now,
+		Updated:  now,
+	}
+	if mode == SplitImmediate {
+		split.Status = types.SplitStatusPaid
+	}
+	s.splits = append(s.splits, split)
+	for i, part := range parts {
+		share := &types.SplitShare{
+			SplitID:   split.ID,
+			AccountID: part.AccountID,
+			Amount:    part.Amount,
+			Status:    types.ShareStatusPending,
+			Updated:   now,
+		}
+		if results != nil {
+			share.PaymentID = results[i].Payment.ID
+			share.Status = types.ShareStatusAccepted
+		}
+		s.splitShares = append(s.splitShares, share)
+	}
+	return split, nil
+}
+
+// paySplit pays every part like an all-or-nothing batch run in order.
+func (s *Service) paySplit(category types.PaymentCategory, parts []SplitPart) ([]BatchResult, error) {
+	results := make([]BatchResult, len(parts))
+	for i, part := range parts {
+		results[i].Item = BatchItem{AccountID: part.AccountID, Amount: part.Amount, Category: category}
+	}
+	if !s.batchFeasible(results) {
+		for _, result := range results {
+			if result.Err != nil {
+				return nil, result.Err
+			}
+		}
+	}
+	for i := range results {
+		item := results[i].Item
+		results[i].Payment, results[i].Err = s.splitPay(item.AccountID, item.Amount, item.Category)
+		if results[i].Err != nil {
+			if err := s.rollbackBatch(results); err != nil {
+				return nil, err
+			}
+			return nil, results[i].Err
+		}
+	}
+	return results, nil
+}
+
+// splitPay pays a share. SplitPayment already counted an unknown category
+// in permissive mode, so only the strict validation is repeated.
+func (s *Service) splitPay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	if s.categoryMode != CategoryModePermissive {
+		return s.checkedPay(accountID, amount, category, "")
+	}
+	return s.screenedPay(accountID, amount, category, "")
+}
+
+// paymentShare finds the split share paid by the payment.
+func (s *Service) paymentShare(paymentID string) *types.SplitShare {
+	for _, share := range s.splitShares {
+		if share.PaymentID == paymentID {
+			return share
+		}
+	}
+	return nil
+}
+
+func (s *Service) FindSplit(splitID string) (*types.PaymentSplit, error) {
+	for _, split := range s.splits {
+		if split.ID == splitID {
+			return split, nil
+		}
+	}
+	return nil, ErrSplitNotFound
+}
+
+// SplitShares returns the participants' shares in the order they were given.
+func (s *Service) SplitShares(splitID string) []types.SplitShare {
+	var shares []types.SplitShare
+	for _, share := range s.splitShares {
+		if share.SplitID == splitID {
+			shares = append(shares, *share)
+		}
+	}
+	return shares
+}
+
+// SplitRequests returns the split payments waiting for the account to
+// accept or decline its share.
+func (s *Service) SplitRequests(accountID int64) []types.SplitShare {
+	var shares []types.SplitShare
+	for _, share := range s.splitShares {
+		if share.AccountID != accountID || share.Status != types.ShareStatusPending {
+			continue
+		}
+		split, err := s.FindSplit(share.SplitID)
+		if err == nil && split.Status == types.SplitStatusPending {
+			shares = append(shares, *share)
+		}
+	}
+	return shares
+}
+
+// pendingShare finds the account's unanswered share of a pending split.
+func (s *Service) pendingShare(splitID string, accountID int64) (*types.PaymentSplit, *types.SplitShare, error) {
+	split, err := s.FindSplit(splitID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if split.Status != types.SplitStatusPending {
+		return nil, nil, ErrSplitClosed
+	}
+	for _, share := range s.splitShares {
+		if share.SplitID != splitID || share.AccountID != accountID {
+			continue
+		}
+		if share.Status != types.ShareStatusPending {
+			return nil, nil, ErrShareAnswered
+		}
+		return split, share, nil
+	}
+	return nil, nil, ErrNotSplitParticipant
+}
+
+// AcceptSplit pays the account's share of a requested split. The split is
+// paid once every participant has accepted.
+func (s *Service) AcceptSplit(splitID string, accountID int64) (payment *types.Payment, err error) {
+	defer func() {
+		s.record("AcceptSplit", auditArgs("split", splitID, "account", accountID), paymentResult(payment), err)
+	}()
+
+	split, share, err := s.pendingShare(splitID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	payment, err = s.splitPay(accountID, share.Amount, split.Category)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock().UTC()
+	share.PaymentID = payment.ID
+	share.Status = types.ShareStatusAccepted
+	share.Updated = now
+	for _, other := range s.splitShares {
+		if other.SplitID == splitID && other.Status != types.ShareStatusAccepted {
+			return payment, nil
+		}
+	}
+	split.Status = types.SplitStatusPaid
+	split.Updated = now
+	return payment, nil
+}
+
+// DeclineSplit declines the account's share, which fails the whole split:
+// the shares already accepted are refunded.
+func (s *Service) DeclineSplit(splitID string, accountID int64) (err error) {
+	defer func() {
+		s.record("DeclineSplit", auditArgs("split", splitID, "account", accountID), "", err)
+	}()
+
+	split, share, err := s.pendingShare(splitID, accountID)
+	if err != nil {
+		return err
+	}
+	err = s.rejectSplitPayments(splitID)
+	if err != nil {
+		return err
+	}
+	now := s.clock().UTC()
+	share.Status = types.ShareStatusDeclined
+	share.Updated = now
+	split.Status = types.SplitStatusDeclined
+	split.Updated = now
+	return nil
+}
+
+// RejectSplit rejects the whole split: every child payment is rejected and
+// the requests still pending are withdrawn.
+func (s *Service) RejectSplit(splitID string) (err error) {
+	defer func() {
+		s.record("RejectSplit", auditArgs("split", splitID), "", err)
+	}()
+
+	split, err := s.FindSplit(splitID)
+	if err != nil {
+		return err
+	}
+	if split.Status == types.SplitStatusDeclined || split.Status == types.SplitStatusRejected {
+		return ErrSplitClosed
+	}
+	return s.rejectSplit(split)
+}
+
+func (s *Service) rejectSplit(split *types.PaymentSplit) error {
+	err := s.rejectSplitPayments(split.ID)
+	if err != nil {
+		return err
+	}
+	split.Status = types.SplitStatusRejected
+	split.Updated = s.clock().UTC()
+	return nil
+}
+
+func (s *Service) rejectSplitPayments(splitID string) error {
+	for _, share := range s.splitShares {
+		if share.SplitID != splitID || share.PaymentID == "" {
+			continue
+		}
+		payment, err := s.FindPaymentByID(share.PaymentID)
+		if err != nil {
+			return err
+		}
+		if payment.Status == types.PaymentStatusFail {
+			continue
+		}
+		err = s.rejectPayment(payment)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitRows(splits []*types.PaymentSplit) [][]string {
+	rows := make([][]string, len(splits))
+	for i, split := range splits {
+		rows[i] = []string{
+			split.ID,
+			strconv.FormatInt(int64(split.Amount), 10),
+			string(split.Category),
+			split.Mode,
+			string(split.Status),
+			formatTime(split.Created),
+			formatTime(split.Updated),
+		}
+	}
+	return rows
+}
+
+func parseSplits(rows [][]string) []*types.PaymentSplit {
+	splits := make([]*types.PaymentSplit, 0, len(rows))
+	for _, row := range rows {
+		amount, _ := strconv.ParseInt(dumpField(row, 1), 10, 64)
+		splits = append(splits, &types.PaymentSplit{
+			ID:       row[0],
+			Amount:   types.Money(amount),
+			Category: types.PaymentCategory(dumpField(row, 2)),
+			Mode:     dumpField(row, 3),
+			Status:   types.SplitStatus(dumpField(row, 4)),
+			Created:  parseTime(dumpField(row, 5)),
+			Updated:  parseTime(dumpField(row, 6)),
+		})
+	}
+	return splits
+}
+
+func splitShareRows(shares []*types.SplitShare) [][]string {
+	rows := make([][]string, len(shares))
+	for i, share := range shares {
+		rows[i] = []string{
+			share.SplitID,
+			strconv.FormatInt(share.AccountID, 10),
+			strconv.FormatInt(int64(share.Amount), 10),
+			share.PaymentID,
+			string(share.Status),
+			formatTime(share.Updated),
+		}
+	}
+	return rows
+}
+
+func parseSplitShares(rows [][]string) []*types.SplitShare {
+	shares := make([]*types.SplitShare, 0, len(rows))
+	for _, row := range rows {
+		accID, _ := strconv.ParseInt(dumpField(row, 1), 10, 64)
+		amount, _ := strconv.ParseInt(dumpField(row, 2), 10, 64)
+		shares = append(shares, &types.SplitShare{
+			SplitID:   row[0],
+			AccountID: accID,
+			Amount:    types.Money(amount),
+			PaymentID: dumpField(row, 3),
+			Status:    types.ShareStatus(dumpField(row, 4)),
+			Updated:   parseTime(dumpField(row, 5)),
+		})
+	}
+	return shares
+}
@@ -0,0 +1,193 @@
+package wallet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestEqualParts(t *testing.T) {
+	got := EqualParts(1_000, 1, 2, 3)
+	want := []SplitPart{{1, 334}, {2, 333}, {3, 333}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EqualParts() = %v, want %v", got, want)
+	}
+}
+
+func TestService_SplitPayment_immediate(t *testing.T) {
+	s := newTestService()
+	var accounts []*types.Account
+	for _, data := range []struct {
+		phone   types.Phone
+		balance types.Money
+	}{{"+992985570302", 10_000}, {"+992900000000", 10_000}, {"+992900000001", 500}} {
+		account, err := s.addAccountWithBalance(data.phone, data.balance)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		accounts = append(accounts, account)
+	}
+
+	_, err := s.SplitPayment(3_000, "restaurant", EqualParts(3_000, 1, 2, 3), SplitImmediate)
+	if err != ErrNotEnoughBalance {
+		t.Errorf("SplitPayment(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+	if len(s.payments) != 0 || accounts[0].Balance != 10_000 {
+		t.Errorf("SplitPayment(): nothing must be paid, payments = %d", len(s.payments))
+	}
+	_, err = s.SplitPayment(3_000, "restaurant", []SplitPart{{1, 2_000}, {2, 500}}, SplitImmediate)
+	if err != ErrInvalidSplit {
+		t.Errorf("SplitPayment(): must return ErrInvalidSplit, returned = %v", err)
+	}
+
+	split, err := s.SplitPayment(3_000, "restaurant", []SplitPart{{1, 2_000}, {2, 500}, {3, 500}}, SplitImmediate)
+	if err != nil {
+		t.Errorf("SplitPayment(): error = %v", err)
+		return
+	}
+	shares := s.SplitShares(split.ID)
+	if split.Status != types.SplitStatusPaid || len(shares) != 3 || shares[0].PaymentID == "" || accounts[2].Balance != 0 {
+		t.Errorf("SplitPayment(): split = %v, shares = %v", split, shares)
+	}
+	if err := s.Reject(shares[0].PaymentID); err != ErrSplitShare {
+		t.Errorf("Reject(): must return ErrSplitShare, returned = %v", err)
+	}
+
+	err = s.RejectSplit(split.ID)
+	if err != nil {
+		t.Errorf("RejectSplit(): error = %v", err)
+		return
+	}
+	if accounts[0].Balance != 10_000 || accounts[1].Balance != 10_000 || accounts[2].Balance != 500 {
+		t.Errorf("RejectSplit(): balances = %d, %d, %d", accounts[0].Balance, accounts[1].Balance, accounts[2].Balance)
+	}
+	if err := s.RejectSplit(split.ID); err != ErrSplitClosed {
+		t.Errorf("RejectSplit(): must return ErrSplitClosed, returned = %v", err)
+	}
+}
+
+func TestService_SplitPayment_request(t *testing.T) {
+	s := newTestService()
+	first, err := s.addAccountWithBalance("+992985570302", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.addAccountWithBalance("+992900000000", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	split, err := s.SplitPayment(4_000, "restaurant", EqualParts(4_000, 1, 2), SplitRequest)
+	if err != nil {
+		t.Errorf("SplitPayment(): error = %v", err)
+		return
+	}
+	if split.Status != types.SplitStatusPending || len(s.SplitRequests(2)) != 1 || len(s.payments) != 0 {
+		t.Errorf("SplitPayment(): split = %v, requests = %v", split, s.SplitRequests(2))
+	}
+	_, err = s.AcceptSplit(split.ID, 1)
+	if err != nil {
+		t.Errorf("AcceptSplit(): error = %v", err)
+		return
+	}
+	if _, err := s.AcceptSplit(split.ID, 1); err != ErrShareAnswered {
+		t.Errorf("AcceptSplit(): must return ErrShareAnswered, returned = %v", err)
+	}
+	if _, err := s.AcceptSplit(split.ID, 3); err != ErrNotSplitParticipant {
+		t.Errorf("AcceptSplit(): must return ErrNotSplitParticipant, returned = %v", err)
+	}
+
+	err = s.DeclineSplit(split.ID, 2)
+	if err != nil {
+		t.Errorf("DeclineSplit(): error = %v", err)
+		return
+	}
+	if split.Status != types.SplitStatusDeclined || first.Balance != 10_000 || len(s.SplitRequests(2)) != 0 {
+		t.Errorf("DeclineSplit(): split = %v, balance = %d", split, first.Balance)
+	}
+
+	split, err = s.SplitPayment(4_000, "restaurant", EqualParts(4_000, 1, 2), SplitRequest)
+	if err != nil {
+		t.Errorf("SplitPayment(): error = %v", err)
+		return
+	}
+	payment, err := s.AcceptSplit(split.ID, 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.AcceptSplit(split.ID, 2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if split.Status != types.SplitStatusPaid || first.Balance != 8_000 || second.Balance != 8_000 {
+		t.Errorf("AcceptSplit(): split = %v, balances = %d, %d", split, first.Balance, second.Balance)
+	}
+	// counted once per SplitPayment, not again for each share
+	if unknown := s.UnknownCategories(); unknown["restaurant"] != 2 {
+		t.Errorf("UnknownCategories(): got %v", unknown)
+	}
+
+	_, err = s.FavoritePayment(payment.ID, "dinner")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Errorf("Export(): error = %v", err)
+		return
+	}
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+	found, err := imported.FindSplit(split.ID)
+	if err != nil || *found != *split || !reflect.DeepEqual(imported.SplitShares(split.ID), s.SplitShares(split.ID)) {
+		t.Errorf("Import(): split = %v, error = %v", found, err)
+	}
+}
+
+func TestService_DeclinePayment_splitShare(t *testing.T) {
+	s := newTestService()
+	first, err := s.addAccountWithBalance("+992985570302", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := s.addAccountWithBalance("+992900000000", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetRiskChecks(func(request RiskRequest) RiskVerdict {
+		if request.Account.ID == second.ID {
+			return RiskVerdict{Outcome: types.RiskReview, Rule: "review"}
+		}
+		return RiskVerdict{}
+	})
+
+	split, err := s.SplitPayment(4_000, "restaurant", EqualParts(4_000, 1, 2), SplitImmediate)
+	if err != nil {
+		t.Errorf("SplitPayment(): error = %v", err)
+		return
+	}
+	held := s.SplitShares(split.ID)[1].PaymentID
+	err = s.DeclinePayment(held)
+	if err != nil {
+		t.Errorf("DeclinePayment(): error = %v", err)
+		return
+	}
+	// a declined share fails the whole split
+	if split.Status != types.SplitStatusRejected || first.Balance != 10_000 || second.Balance != 10_000 {
+		t.Errorf("DeclinePayment(): split = %v, balances = %d, %d", split, first.Balance, second.Balance)
+	}
+}
@@ -0,0 +1,116 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+// Statement covers [From, To). Closing is computed from the ledger.
+// Discrepancy is the difference between Account.Balance and the balance at
+// the end of the whole ledger, whatever the period, so a statement for an
+// earlier month still warns about an account that doesn't add up.
+type Statement struct {
+	AccountID   int64         `json:"accountId"`
+	Phone       types.Phone   `json:"phone"`
+	From        time.Time     `json:"from"`
+	To          time.Time     `json:"to"`
+	Opening     types.Money   `json:"opening"`
+	Credits     types.Money   `json:"credits"`
+	Debits      types.Money   `json:"debits"`
+	Closing     types.Money   `json:"closing"`
+	Entries     []LedgerEntry `json:"entries"`
+	Reconciled  bool          `json:"reconciled"`
+	Discrepancy types.Money   `json:"discrepancy"`
+}
+
+func (s *Service) Statement(accountID int64, from time.Time, to time.Time) (*Statement, error) {
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &Statement{
+		AccountID: accountID,
+		Phone:     account.Phone,
+		From:      from,
+		To:        to,
+	}
+	ledger := s.ledger(accountID)
+	for _, entry := range ledger {
+		switch {
+		case entry.Time.Before(from):
+			statement.Opening = entry.Balance
+		case entry.Time.Before(to):
+			statement.Entries = append(statement.Entries, entry)
+			if entry.Amount > 0 {
+				statement.Credits += entry.Amount
+			} else {
+				statement.Debits -= entry.Amount
+			}
+		}
+	}
+	statement.Closing = statement.Opening + statement.Credits - statement.Debits
+
+	expected := types.Money(0)
+	if len(ledger) > 0 {
+		expected = ledger[len(ledger)-1].Balance
+	}
+	statement.Discrepancy = account.Balance - expected
+	statement.Reconciled = statement.Discrepancy == 0
+	return statement, nil
+}
+
+func (s *Service) MonthlyStatement(accountID int64, year int, month time.Month) (*Statement, error) {
+	from := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	return s.Statement(accountID, from, from.AddDate(0, 1, 0))
+}
+
+func (st *Statement) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Statement for account %d (%s)\n", st.AccountID, st.Phone)
+	fmt.Fprintf(tw, "Period: %s - %s\n\n", st.From.Format("2006-01-02"), st.To.Add(-time.Nanosecond).Format("2006-01-02"))
+	money := func(m types.Money) string { return m.Format(types.LocalePlain) }
+	fmt.Fprintf(tw, "Opening balance\t\t\t%s\n", money(st.Opening))
+	for _, entry := range st.Entries {
+		fmt.Fprintf(tw, "%s\t%s %s\t%s\t%s\n", entry.Time.Format("2006-01-02 15:04"), entry.Kind, entry.Category, money(entry.Amount), money(entry.Balance))
+	}
+	fmt.Fprintf(tw, "Closing balance\t\t\t%s\n\n", money(st.Closing))
+	fmt.Fprintf(tw, "Credits: %s, debits: %s\n", money(st.Credits), money(st.Debits))
+	if !st.Reconciled {
+		fmt.Fprintf(tw, "WARNING: account balance differs from history by %s\n", money(st.Discrepancy))
+	}
+	return tw.Flush()
+}
+
+func (st *Statement) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(st)
+}
+
+func (st *Statement) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	money := func(m types.Money) string { return strconv.FormatInt(int64(m), 10) }
+
+	rows := [][]string{
+		{"time", "kind", "reference", "category", "amount", "balance"},
+		{st.From.Format(time.RFC3339), "opening", "", "", "", money(st.Opening)},
+	}
+	for _, entry := range st.Entries {
+		rows = append(rows, []string{entry.Time.Format(time.RFC3339), string(entry.Kind), entry.Reference, string(entry.Category), money(entry.Amount), money(entry.Balance)})
+	}
+	rows = append(rows, []string{st.To.Format(time.RFC3339), "closing", "", "", "", money(st.Closing)})
+
+	err := writer.WriteAll(rows)
+	if err != nil {
+		return err
+	}
+	return writer.Error()
+}
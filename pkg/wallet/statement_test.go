@@ -0,0 +1,102 @@
+package wallet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_MonthlyStatement(t *testing.T) {
+	s := newTestService()
+	now := time.Date(2022, 1, 20, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	_, err := s.addAccountWithBalance("+992985570302", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.Pay(1, 100, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now = time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	payment, err := s.Pay(1, 300, "grocery")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Deposit(1, 50)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now = time.Date(2022, 2, 10, 0, 0, 0, 0, time.UTC)
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now = time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	_, err = s.Pay(1, 10, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	statement, err := s.MonthlyStatement(1, 2022, time.February)
+	if err != nil {
+		t.Errorf("MonthlyStatement(): error = %v", err)
+		return
+	}
+	if statement.Opening != 900 || statement.Closing != 950 || len(statement.Entries) != 3 {
+		t.Errorf("MonthlyStatement(): opening = %d, closing = %d, entries = %v", statement.Opening, statement.Closing, statement.Entries)
+	}
+	if statement.Credits != 350 || statement.Debits != 300 {
+		t.Errorf("MonthlyStatement(): credits = %d, debits = %d", statement.Credits, statement.Debits)
+	}
+	if !statement.Reconciled {
+		t.Errorf("MonthlyStatement(): not reconciled, discrepancy = %d", statement.Discrepancy)
+	}
+
+	var buf bytes.Buffer
+	err = statement.WriteText(&buf)
+	if err != nil {
+		t.Errorf("WriteText(): error = %v", err)
+		return
+	}
+	if !strings.Contains(buf.String(), "Closing balance") || strings.Contains(buf.String(), "WARNING") {
+		t.Errorf("WriteText(): unexpected statement\n%s", buf.String())
+	}
+	buf.Reset()
+	err = statement.WriteCSV(&buf)
+	if err != nil {
+		t.Errorf("WriteCSV(): error = %v", err)
+		return
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 6 {
+		t.Errorf("WriteCSV(): got %d lines, want %d", lines, 6)
+	}
+}
+
+func TestService_Statement_discrepancy(t *testing.T) {
+	s := newTestService()
+	_, _, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	account, _ := s.FindAccountByID(1)
+	account.Balance += 5
+
+	statement, err := s.Statement(1, time.Time{}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Errorf("Statement(): error = %v", err)
+		return
+	}
+	if statement.Reconciled || statement.Discrepancy != 5 {
+		t.Errorf("Statement(): reconciled = %v, discrepancy = %d", statement.Reconciled, statement.Discrepancy)
+	}
+}
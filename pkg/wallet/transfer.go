@@ -0,0 +1,102 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+var ErrSameAccount = errors.New("can't transfer to the same account")
+
+// TransferCategory marks payments that move money to another wallet account.
+// It bypasses the category registry because it isn't a merchant category.
+const TransferCategory types.PaymentCategory = "p2p"
+
+func transferSource(payment *types.Payment) string {
+	return "p2p:" + payment.ID
+}
+
+func (s *Service) PayToPhone(accountID int64, phone types.Phone, amount types.Money) (payment *types.Payment, err error) {
+	defer func() {
+		s.record("PayToPhone", auditArgs("account", accountID, "phone", phone, "amount", amount), paymentResult(payment), err)
+	}()
+
+	recipient, err := s.FindAccountByPhone(phone)
+	if err != nil {
+		return nil, err
+	}
+	return s.transfer(accountID, recipient.ID, amount, "")
+}
+
+// transfer screens the payment like any other. A transfer held for review
+// reaches the recipient only when ApprovePayment releases it.
+func (s *Service) transfer(accountID int64, recipientID int64, amount types.Money, repeatOf string) (*types.Payment, error) {
+	if accountID == recipientID {
+		return nil, ErrSameAccount
+	}
+	recipient, err := s.FindAccountByID(recipientID)
+	if err != nil {
+		return nil, err
+	}
+	if s.phoneBlocked(recipient.Phone) {
+		s.screeningMatch(accountID, recipient.Phone, "", "Transfer")
+		return nil, ErrBlockedPhone
+	}
+
+	payment, err := s.screenedPay(accountID, amount, TransferCategory, repeatOf)
+	if err != nil {
+		return nil, err
+	}
+	payment.RecipientID = recipientID
+	if payment.Status == types.PaymentStatusReview {
+		return payment, nil
+	}
+	err = s.completeTransfer(payment)
+	if err != nil {
+		// the payer was already debited, refund it as rollbackBatch does
+		if rerr := s.rejectPayment(payment); rerr != nil {
+			return nil, fmt.Errorf("%w: refund failed: %v", err, rerr)
+		}
+		return nil, err
+	}
+	return payment, nil
+}
+
+// completeTransfer credits the recipient of a transfer. Only a completed
+// transfer has the OK status.
+func (s *Service) completeTransfer(payment *types.Payment) error {
+	_, err := s.deposit(payment.RecipientID, payment.Amount, transferSource(payment))
+	if err != nil {
+		return err
+	}
+	payment.Status = types.PaymentStatusOk
+	return nil
+}
+
+// reverseTransfer takes the money back from the recipient of a rejected
+// transfer before the payer is refunded.
+func (s *Service) reverseTransfer(payment *types.Payment) error {
+	for _, deposit := range s.deposits {
+		if deposit.Source != transferSource(payment) {
+			continue
+		}
+		if !deposit.Reversed.IsZero() {
+			return ErrDepositReversed
+		}
+		account, err := s.FindAccountByID(deposit.AccountID)
+		if err != nil {
+			return err
+		}
+		if available(account) < deposit.Amount {
+			return ErrNotEnoughBalance
+		}
+		err = s.debit(account, deposit.Amount)
+		if err != nil {
+			return err
+		}
+		deposit.Reversed = s.clock().UTC()
+		return nil
+	}
+	return ErrDepositNotFound
+}
@@ -0,0 +1,177 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestService_ChangePhone(t *testing.T) {
+	s := newTestService()
+	_, err := s.RegisterAccount("+992985570302")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.RegisterAccount("+992981111111")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = s.ChangePhone(1, "981111111")
+	if err != ErrPhoneRegistered {
+		t.Errorf("ChangePhone(): must return ErrPhoneRegistered, returned = %v", err)
+	}
+	err = s.ChangePhone(1, "900000000")
+	if err != nil {
+		t.Errorf("ChangePhone(): error = %v", err)
+		return
+	}
+
+	account, err := s.FindAccountByPhone("992900000000")
+	if err != nil || account.ID != 1 {
+		t.Errorf("FindAccountByPhone(): account = %v, error = %v", account, err)
+	}
+	_, err = s.FindAccountByPhone("+992985570302")
+	if err != ErrAccountNotFound {
+		t.Errorf("FindAccountByPhone(): must return ErrAccountNotFound, returned = %v", err)
+	}
+	history, err := s.PhoneHistory(1)
+	if err != nil || len(history) != 1 || history[0].Old != "+992985570302" || history[0].New != "+992900000000" {
+		t.Errorf("PhoneHistory(): history = %v, error = %v", history, err)
+	}
+}
+
+func TestService_PayToPhone(t *testing.T) {
+	s := newTestService()
+	_, err := s.addAccountWithBalance("+992985570302", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.RegisterAccount("+992981111111")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	payment, err := s.PayToPhone(1, "981111111", 300)
+	if err != nil {
+		t.Errorf("PayToPhone(): error = %v", err)
+		return
+	}
+	payer, _ := s.FindAccountByID(1)
+	recipient, _ := s.FindAccountByID(2)
+	if payer.Balance != 700 || recipient.Balance != 300 || payment.RecipientID != 2 {
+		t.Errorf("PayToPhone(): payer = %v, recipient = %v", payer, recipient)
+	}
+	_, err = s.PayToPhone(1, "985570302", 1)
+	if err != ErrSameAccount {
+		t.Errorf("PayToPhone(): must return ErrSameAccount, returned = %v", err)
+	}
+
+	_, err = s.Repeat(payment.ID)
+	if err != nil {
+		t.Errorf("Repeat(): error = %v", err)
+		return
+	}
+	if recipient.Balance != 600 {
+		t.Errorf("Repeat(): transfer not repeated to recipient = %v", recipient)
+	}
+
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Errorf("Reject(): error = %v", err)
+		return
+	}
+	if payer.Balance != 700 || recipient.Balance != 300 {
+		t.Errorf("Reject(): payer = %v, recipient = %v", payer, recipient)
+	}
+	if mismatches := s.VerifyBalances(); len(mismatches) != 0 {
+		t.Errorf("VerifyBalances(): got mismatches = %v", mismatches)
+	}
+}
+
+func TestService_Pay_transferCategory(t *testing.T) {
+	s := newTestService()
+	_, err := s.addAccountWithBalance("+992985570302", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, category := range []types.PaymentCategory{TransferCategory, OverdraftInterestCategory, CashbackClawbackCategory} {
+		_, err = s.Pay(1, 100, category)
+		if err != ErrInvalidCategory {
+			t.Errorf("Pay(%s): must return ErrInvalidCategory, returned = %v", category, err)
+		}
+	}
+}
+
+func TestService_PayToPhone_creditFails(t *testing.T) {
+	s := newTestService()
+	payer, err := s.addAccountWithBalance("+992985570302", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	recipient, err := s.addAccountWithBalance("+992981111111", types.MaxMoney)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = s.PayToPhone(payer.ID, recipient.Phone, 300)
+	if !errors.Is(err, types.ErrMoneyOverflow) {
+		t.Errorf("PayToPhone(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+	// the payer gets the debit back
+	if payer.Balance != 1_000 || recipient.Balance != types.MaxMoney {
+		t.Errorf("PayToPhone(): payer = %v, recipient = %v", payer, recipient)
+	}
+	if len(s.payments) != 1 || s.payments[0].Status != types.PaymentStatusFail {
+		t.Errorf("PayToPhone(): payments = %v", s.payments)
+	}
+	if mismatches := s.VerifyBalances(); len(mismatches) != 0 {
+		t.Errorf("VerifyBalances(): got mismatches = %v", mismatches)
+	}
+}
+
+func TestService_ApprovePayment_creditFails(t *testing.T) {
+	s := newTestService()
+	payer, err := s.addAccountWithBalance("+992985570302", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	recipient, err := s.addAccountWithBalance("+992981111111", types.MaxMoney)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.SetRiskChecks(func(RiskRequest) RiskVerdict {
+		return RiskVerdict{Outcome: types.RiskReview}
+	})
+	payment, err := s.PayToPhone(payer.ID, recipient.Phone, 300)
+	if err != nil {
+		t.Errorf("PayToPhone(): error = %v", err)
+		return
+	}
+
+	err = s.ApprovePayment(payment.ID)
+	if !errors.Is(err, types.ErrMoneyOverflow) {
+		t.Errorf("ApprovePayment(): must return ErrMoneyOverflow, returned = %v", err)
+	}
+	if payment.Status != types.PaymentStatusReview {
+		t.Errorf("ApprovePayment(): payment must stay held, got %v", payment)
+	}
+	err = s.DeclinePayment(payment.ID)
+	if err != nil {
+		t.Errorf("DeclinePayment(): error = %v", err)
+		return
+	}
+	if payer.Balance != 1_000 || recipient.Balance != types.MaxMoney {
+		t.Errorf("DeclinePayment(): payer = %v, recipient = %v", payer, recipient)
+	}
+}
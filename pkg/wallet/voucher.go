@@ -0,0 +1,278 @@
+package wallet
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/adheeeem/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrVoucherNotFound = errors.New("voucher not found")
+var ErrVoucherExists = errors.New("voucher already exists")
+var ErrVoucherExpired = errors.New("voucher expired")
+var ErrVoucherUsedUp = errors.New("voucher usage limit reached")
+var ErrInvalidVoucher = errors.New("invalid voucher")
+var ErrVoucherNotApplicable = errors.New("voucher not applicable")
+
+func voucherSource(code string) string {
+	return "voucher:" + code
+}
+
+// CreateVoucher registers a voucher. An empty code is generated. Credit
+// vouchers need Amount; discount vouchers take either a fixed Amount or
+// Percent basis points off the payment.
+func (s *Service) CreateVoucher(voucher types.Voucher) (created *types.Voucher, err error) {
+	defer func() {
+		code := voucher.Code
+		if created != nil {
+			code = created.Code
+		}
+		s.record("CreateVoucher", auditArgs("code", code, "kind", voucher.Kind, "amount", voucher.Amount, "percent", voucher.Percent), "", err)
+	}()
+
+	voucher.Code = strings.ToUpper(strings.TrimSpace(voucher.Code))
+	if voucher.Code == "" {
+		voucher.Code = strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:10])
+	}
+	switch {
+	case voucher.MaxUses < 0 || voucher.MaxUsesPerAccount < 0 || voucher.Amount < 0 || voucher.Percent < 0:
+		return nil, ErrInvalidVoucher
+	case voucher.Kind == types.VoucherCredit && (voucher.Amount == 0 || voucher.Percent != 0):
+		return nil, ErrInvalidVoucher
+	case voucher.Kind == types.VoucherDiscount && (voucher.Amount == 0) == (voucher.Percent == 0):
+		return nil, ErrInvalidVoucher
+	case voucher.Kind == types.VoucherDiscount && voucher.Percent >= basisPoints:
+		return nil, ErrInvalidVoucher
+	case voucher.Kind != types.VoucherCredit && voucher.Kind != types.VoucherDiscount:
+		return nil, ErrInvalidVoucher
+	}
+	if _, err := s.FindVoucher(voucher.Code); err == nil {
+		return nil, ErrVoucherExists
+	}
+
+	voucher.Uses = 0
+	voucher.Created = s.clock().UTC()
+	s.vouchers = append(s.vouchers, &voucher)
+	return &voucher, nil
+}
+
+func (s *Service) FindVoucher(code string) (*types.Voucher, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for _, voucher := range s.vouchers {
+		if voucher.Code == code {
+			return voucher, nil
+		}
+	}
+
+	return nil, ErrVoucherNotFound
+}
+
+func (s *Service) usableVoucher(accountID int64, code string, kind types.VoucherKind) (*types.Voucher, error) {
+	voucher, err := s.FindVoucher(code)
+	if err != nil {
+		return nil, err
+	}
+	if voucher.Kind != kind {
+		return nil, ErrVoucherNotApplicable
+	}
+	if !voucher.Expires.IsZero() && !s.clock().Before(voucher.Expires) {
+		return nil, ErrVoucherExpired
+	}
+	if voucher.MaxUses > 0 && voucher.Uses >= voucher.MaxUses {
+		return nil, ErrVoucherUsedUp
+	}
+	if voucher.MaxUsesPerAccount > 0 {
+		uses := 0
+		for _, redemption := range s.voucherRedemptions {
+			if redemption.Code == voucher.Code && redemption.AccountID == accountID && redemption.Reverted.IsZero() {
+				uses++
+			}
+		}
+		if uses >= voucher.MaxUsesPerAccount {
+			return nil, ErrVoucherUsedUp
+		}
+	}
+	return voucher, nil
+}
+
+func (s *Service) redeem(voucher *types.Voucher, accountID int64, amount types.Money) *types.VoucherRedemption {
+	voucher.Uses++
+	redemption := &types.VoucherRedemption{
+		ID:        uuid.New().String(),
+		Code:      voucher.Code,
+		AccountID: accountID,
+		Amount:    amount,
+		Created:   s.clock().UTC(),
+	}
+	s.voucherRedemptions = append(s.voucherRedemptions, redemption)
+	return redemption
+}
+
+// RedeemVoucher credits a credit voucher to the account as a deposit.
+func (s *Service) RedeemVoucher(accountID int64, code string) (deposit *types.Deposit, err error) {
+	defer func() {
+		s.record("RedeemVoucher", auditArgs("account", accountID, "code", code), "", err)
+	}()
+
+	if _, err := s.FindAccountByID(accountID); err != nil {
+		return nil, err
+	}
+	voucher, err := s.usableVoucher(accountID, code, types.VoucherCredit)
+	if err != nil {
+		return nil, err
+	}
+	deposit, err = s.deposit(accountID, voucher.Amount, voucherSource(voucher.Code))
+	if err != nil {
+		return nil, err
+	}
+	s.redeem(voucher, accountID, voucher.Amount).DepositID = deposit.ID
+	return deposit, nil
+}
+
+// PayWithVoucher pays amount minus the voucher discount. The discount must
+// leave something to pay.
+func (s *Service) PayWithVoucher(accountID int64, amount types.Money, category types.PaymentCategory, code string) (payment *types.Payment, err error) {
+	defer func() {
+		s.record("PayWithVoucher", auditArgs("account", accountID, "amount", amount, "category", category, "code", code), paymentResult(payment), err)
+	}()
+
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	if _, err := s.FindAccountByID(accountID); err != nil {
+		return nil, err
+	}
+	err = s.validateCategory(category)
+	if err != nil {
+		return nil, err
+	}
+	voucher, err := s.usableVoucher(accountID, code, types.VoucherDiscount)
+	if err != nil {
+		return nil, err
+	}
+
+	discount := voucher.Amount
+	if voucher.Percent > 0 {
+		discount, err = amount.Mul(voucher.Percent)
+		if err != nil {
+			return nil, err
+		}
+		discount /= basisPoints
+	}
+	if discount >= amount {
+		return nil, ErrVoucherNotApplicable
+	}
+
+	payment, err = s.screenedPay(accountID, amount-discount, category, "")
+	if err != nil {
+		return nil, err
+	}
+	s.redeem(voucher, accountID, discount).PaymentID = payment.ID
+	return payment, nil
+}
+
+// revertVoucher gives the voucher use back when its payment is rejected.
+func (s *Service) revertVoucher(payment *types.Payment) {
+	for _, redemption := range s.voucherRedemptions {
+		if redemption.PaymentID != payment.ID || !redemption.Reverted.IsZero() {
+			continue
+		}
+		if voucher, err := s.FindVoucher(redemption.Code); err == nil {
+			voucher.Uses--
+		}
+		redemption.Reverted = s.clock().UTC()
+	}
+}
+
+func (s *Service) AccountVoucherRedemptions(accountID int64) ([]types.VoucherRedemption, error) {
+	_, err := s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	var redemptions []types.VoucherRedemption
+	for _, redemption := range s.voucherRedemptions {
+		if redemption.AccountID == accountID {
+			redemptions = append(redemptions, *redemption)
+		}
+	}
+	return redemptions, nil
+}
+
+func voucherRows(vouchers []*types.Voucher) [][]string {
+	rows := make([][]string, len(vouchers))
+	for i, voucher := range vouchers {
+		rows[i] = []string{
+			voucher.Code,
+			string(voucher.Kind),
+			strconv.FormatInt(int64(voucher.Amount), 10),
+			strconv.FormatInt(voucher.Percent, 10),
+			formatTime(voucher.Expires),
+			strconv.Itoa(voucher.MaxUses),
+			strconv.Itoa(voucher.MaxUsesPerAccount),
+			strconv.Itoa(voucher.Uses),
+			formatTime(voucher.Created),
+		}
+	}
+	return rows
+}
+
+func parseVouchers(rows [][]string) []*types.Voucher {
+	vouchers := make([]*types.Voucher, 0, len(rows))
+	for _, row := range rows {
+		amount, _ := strconv.ParseInt(dumpField(row, 2), 10, 64)
+		percent, _ := strconv.ParseInt(dumpField(row, 3), 10, 64)
+		maxUses, _ := strconv.Atoi(dumpField(row, 5))
+		maxPerAccount, _ := strconv.Atoi(dumpField(row, 6))
+		uses, _ := strconv.Atoi(dumpField(row, 7))
+		vouchers = append(vouchers, &types.Voucher{
+			Code:              row[0],
+			Kind:              types.VoucherKind(dumpField(row, 1)),
+			Amount:            types.Money(amount),
+			Percent:           percent,
+			Expires:           parseTime(dumpField(row, 4)),
+			MaxUses:           maxUses,
+			MaxUsesPerAccount: maxPerAccount,
+			Uses:              uses,
+			Created:           parseTime(dumpField(row, 8)),
+		})
+	}
+	return vouchers
+}
+
+func redemptionRows(redemptions []*types.VoucherRedemption) [][]string {
+	rows := make([][]string, len(redemptions))
+	for i, redemption := range redemptions {
+		rows[i] = []string{
+			redemption.ID,
+			redemption.Code,
+			strconv.FormatInt(redemption.AccountID, 10),
+			strconv.FormatInt(int64(redemption.Amount), 10),
+			redemption.DepositID,
+			redemption.PaymentID,
+			formatTime(redemption.Created),
+			formatTime(redemption.Reverted),
+		}
+	}
+	return rows
+}
+
+func parseRedemptions(rows [][]string) []*types.VoucherRedemption {
+	redemptions := make([]*types.VoucherRedemption, 0, len(rows))
+	for _, row := range rows {
+		accID, _ := strconv.ParseInt(dumpField(row, 2), 10, 64)
+		amount, _ := strconv.ParseInt(dumpField(row, 3), 10, 64)
+		redemptions = append(redemptions, &types.VoucherRedemption{
+			ID:        row[0],
+			Code:      dumpField(row, 1),
+			AccountID: accID,
+			Amount:    types.Money(amount),
+			DepositID: dumpField(row, 4),
+			PaymentID: dumpField(row, 5),
+			Created:   parseTime(dumpField(row, 6)),
+			Reverted:  parseTime(dumpField(row, 7)),
+		})
+	}
+	return redemptions
+}
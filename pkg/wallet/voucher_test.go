@@ -0,0 +1,130 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adheeeem/wallet/pkg/types"
+)
+
+func TestService_RedeemVoucher(t *testing.T) {
+	s := newTestService()
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	account, err := s.addAccountWithBalance("+992985570302", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.addAccountWithBalance("+992985570303", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	voucher, err := s.CreateVoucher(types.Voucher{
+		Code:              "welcome",
+		Kind:              types.VoucherCredit,
+		Amount:            500,
+		Expires:           now.AddDate(0, 0, 7),
+		MaxUses:           2,
+		MaxUsesPerAccount: 1,
+	})
+	if err != nil {
+		t.Errorf("CreateVoucher(): error = %v", err)
+		return
+	}
+	_, err = s.CreateVoucher(types.Voucher{Code: "WELCOME", Kind: types.VoucherCredit, Amount: 100})
+	if err != ErrVoucherExists {
+		t.Errorf("CreateVoucher(): must return ErrVoucherExists, returned = %v", err)
+	}
+
+	deposit, err := s.RedeemVoucher(1, "Welcome")
+	if err != nil {
+		t.Errorf("RedeemVoucher(): error = %v", err)
+		return
+	}
+	if deposit.Source != "voucher:WELCOME" || account.Balance != 1_500 {
+		t.Errorf("RedeemVoucher(): deposit = %v, balance = %d", deposit, account.Balance)
+	}
+	_, err = s.RedeemVoucher(1, "WELCOME")
+	if err != ErrVoucherUsedUp {
+		t.Errorf("RedeemVoucher(): must return ErrVoucherUsedUp for the same account, returned = %v", err)
+	}
+
+	now = now.AddDate(0, 0, 8)
+	_, err = s.RedeemVoucher(2, "WELCOME")
+	if err != ErrVoucherExpired {
+		t.Errorf("RedeemVoucher(): must return ErrVoucherExpired, returned = %v", err)
+	}
+	if voucher.Uses != 1 {
+		t.Errorf("RedeemVoucher(): uses = %d, want 1", voucher.Uses)
+	}
+}
+
+func TestService_PayWithVoucher(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992985570302", 10_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = s.CreateVoucher(types.Voucher{Code: "TEN", Kind: types.VoucherDiscount, Percent: 1_000, MaxUses: 1})
+	if err != nil {
+		t.Errorf("CreateVoucher(): error = %v", err)
+		return
+	}
+
+	_, err = s.RedeemVoucher(1, "TEN")
+	if err != ErrVoucherNotApplicable {
+		t.Errorf("RedeemVoucher(): must return ErrVoucherNotApplicable, returned = %v", err)
+	}
+	payment, err := s.PayWithVoucher(1, 2_000, "auto", "TEN")
+	if err != nil {
+		t.Errorf("PayWithVoucher(): error = %v", err)
+		return
+	}
+	if payment.Amount != 1_800 || account.Balance != 8_200 {
+		t.Errorf("PayWithVoucher(): payment = %v, balance = %d", payment, account.Balance)
+	}
+	_, err = s.PayWithVoucher(1, 2_000, "auto", "TEN")
+	if err != ErrVoucherUsedUp {
+		t.Errorf("PayWithVoucher(): must return ErrVoucherUsedUp, returned = %v", err)
+	}
+
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Errorf("Reject(): error = %v", err)
+		return
+	}
+	redemptions, err := s.AccountVoucherRedemptions(1)
+	if err != nil || len(redemptions) != 1 || redemptions[0].Reverted.IsZero() || redemptions[0].Amount != 200 {
+		t.Errorf("AccountVoucherRedemptions(): redemptions = %v, error = %v", redemptions, err)
+	}
+	_, err = s.PayWithVoucher(1, 2_000, "auto", "TEN")
+	if err != nil {
+		t.Errorf("PayWithVoucher(): voucher must be usable after reject, error = %v", err)
+	}
+
+	dir := t.TempDir()
+	_, err = s.FavoritePayment(payment.ID, "auto")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = s.Export(dir)
+	if err != nil {
+		t.Errorf("Export(): error = %v", err)
+		return
+	}
+	imported := &Service{}
+	err = imported.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+		return
+	}
+	voucher, err := imported.FindVoucher("ten")
+	if err != nil || voucher.Uses != 1 || voucher.Percent != 1_000 || len(imported.voucherRedemptions) != 2 {
+		t.Errorf("Import(): voucher = %v, redemptions = %v, error = %v", voucher, imported.voucherRedemptions, err)
+	}
+}